@@ -0,0 +1,65 @@
+// Package backend defines the interfaces that volback's storage backends
+// implement, and the registry that makes a backend usable as a source or
+// destination kind. Backends outside this module register themselves from an
+// init function, the same way the built in ones do.
+package backend
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+type Puller interface {
+	// download
+	Pull(ctx context.Context, path string) (io.Reader, error)
+}
+
+type Pusher interface {
+	// upload
+	Push(ctx context.Context, r io.Reader, path string) error
+}
+
+// Sizer is implemented by pullers that can work out ahead of a pull how many
+// bytes of the source it will read, so progress can be reported with an ETA
+type Sizer interface {
+	Size(ctx context.Context, path string) (int64, error)
+}
+
+// StaleUploadAborter is implemented by backends that upload in parts, which
+// can be left behind by runs that never finished
+type StaleUploadAborter interface {
+	AbortStaleUploads(ctx context.Context, prefix string, olderThan time.Duration) ([]string, error)
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Size int64
+	// Metadata is nil for backends that can't store any
+	Metadata map[string]string
+}
+
+// Lister is implemented by backends that can enumerate the objects below a
+// prefix
+type Lister interface {
+	// List returns the full path of every object below prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Stater is implemented by backends that can describe a stored object
+type Stater interface {
+	// Stat returns an error wrapping fs.ErrNotExist if there is no object at
+	// path
+	Stat(ctx context.Context, path string) (*ObjectInfo, error)
+}
+
+// MetadataPusher is implemented by backends that can store metadata
+// alongside an object
+type MetadataPusher interface {
+	PushWithMetadata(ctx context.Context, r io.Reader, path string, metadata map[string]string) error
+}
+
+// Deleter is implemented by backends that can remove a stored object
+type Deleter interface {
+	Delete(ctx context.Context, path string) error
+}
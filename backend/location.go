@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Location is a source or destination as its backend sees it
+type Location struct {
+	Kind string
+	Path string
+	// Restore is set when a backup is being restored, so sources and
+	// destinations that archive can tell which way the data flows
+	Restore bool
+	// Retry is how failed operations on the location are retried. Whole
+	// pulls and pushes are already retried, backends that upload in parts
+	// can use it to retry each part.
+	Retry Retry
+	// Options configure the backend. They are decoded with Decode into the
+	// struct the backend was registered with.
+	Options map[string]string
+}
+
+// Retry is a location's retry policy, failed operations are retried with
+// exponential backoff. The zero value tries every operation once with no time
+// limit.
+type Retry struct {
+	// Retries is how many times a failed operation is retried
+	Retries int
+	// Backoff is waited before the first retry, and doubled before each one
+	// after it up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each attempt. For pulls it only bounds how long the
+	// object takes to start downloading, and pushes aren't bounded at all
+	// since streaming a backup can take hours. Backends may still use it to
+	// bound each request they make, as S3 does.
+	Timeout time.Duration
+}
+
+// Option describes a field of a backend's options struct, which is set in
+// json by its Name and on the command line by its Flag. Fields are described
+// by struct tags:
+//
+//	json:"name"    the key the option is stored under, required
+//	flag:"name"    the flag name, defaults to Name with - instead of _
+//	usage:"text"   the flag's usage
+//	scope:"src"    only a src. flag, or "dst" for only a dst. flag
+//	sep:":"        separates keys from values in map flags, defaults to =
+//
+// Fields are strings, ints, bools or map[string]string. A map entry is
+// stored under "<name>.<key>".
+type Option struct {
+	Name  string
+	Flag  string
+	Usage string
+	// Kind is reflect.String, reflect.Int, reflect.Bool or reflect.Map
+	Kind reflect.Kind
+	Sep  string
+	// Source and Destination report which of the src. and dst. flags the
+	// option has
+	Source      bool
+	Destination bool
+
+	index []int
+}
+
+// describe lists the options of the struct v points to
+func describe(v any) ([]Option, error) {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("options must be a pointer to a struct, got %T", v)
+	}
+	var opts []Option
+	for _, f := range reflect.VisibleFields(t.Elem()) {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || f.Anonymous || name == "" || name == "-" {
+			continue
+		}
+		opt := Option{
+			Name:        name,
+			Flag:        f.Tag.Get("flag"),
+			Usage:       f.Tag.Get("usage"),
+			Kind:        f.Type.Kind(),
+			Sep:         f.Tag.Get("sep"),
+			Source:      f.Tag.Get("scope") != "dst",
+			Destination: f.Tag.Get("scope") != "src",
+			index:       f.Index,
+		}
+		switch {
+		case opt.Kind == reflect.String, opt.Kind == reflect.Int, opt.Kind == reflect.Bool:
+		case f.Type == reflect.TypeFor[map[string]string]():
+		default:
+			return nil, fmt.Errorf("option %s has unsupported type %s", name, f.Type)
+		}
+		if opt.Flag == "" {
+			opt.Flag = strings.ReplaceAll(name, "_", "-")
+		}
+		if opt.Sep == "" {
+			opt.Sep = "="
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+// Decode sets the fields of the options struct v points to from the
+// location's Options. It fails if an option isn't one of v's fields or its
+// value can't be parsed. Fields without an option keep their value, so v can
+// hold defaults.
+func (l *Location) Decode(v any) error {
+	return decode(l.Options, v, true)
+}
+
+// decode sets v's fields from opts. Options that aren't one of v's fields
+// are an error if strict is set and ignored otherwise.
+func decode(opts map[string]string, v any, strict bool) error {
+	fields, err := describe(v)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]Option, len(fields))
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+
+	dst := reflect.ValueOf(v).Elem()
+	for key, value := range opts {
+		f, ok := byName[key]
+		name, mapKey, isMapKey := strings.Cut(key, ".")
+		if !ok && isMapKey {
+			f, ok = byName[name]
+			ok = ok && f.Kind == reflect.Map
+		}
+		if !ok {
+			if strict {
+				return fmt.Errorf("unknown option %q", key)
+			}
+			continue
+		}
+
+		field := dst.FieldByIndex(f.index)
+		switch f.Kind {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q, expected an integer", key, value)
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q, expected true or false", key, value)
+			}
+			field.SetBool(b)
+		case reflect.Map:
+			if !isMapKey {
+				return fmt.Errorf("invalid %s, expected %s.<key>", key, key)
+			}
+			if field.IsNil() {
+				field.Set(reflect.MakeMap(field.Type()))
+			}
+			field.SetMapIndex(reflect.ValueOf(mapKey), reflect.ValueOf(value))
+		}
+	}
+	return nil
+}
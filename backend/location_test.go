@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testOptions struct {
+	Name    string            `json:"test_name"`
+	Size    int               `json:"test_size"`
+	Enabled bool              `json:"test_enabled"`
+	Labels  map[string]string `json:"test_labels" flag:"test-label" sep:":" scope:"dst"`
+}
+
+func TestLocation_Decode(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options map[string]string
+		want    testOptions
+		wantErr string
+	}{
+		{
+			name:    "all kinds",
+			options: map[string]string{"test_name": "a", "test_size": "42", "test_enabled": "true", "test_labels.env": "prod", "test_labels.team.name": "ops"},
+			want:    testOptions{Name: "a", Size: 42, Enabled: true, Labels: map[string]string{"env": "prod", "team.name": "ops"}},
+		},
+		{
+			name: "defaults kept",
+			want: testOptions{Name: "default"},
+		},
+		{name: "unknown", options: map[string]string{"other": "x"}, wantErr: `unknown option "other"`},
+		{name: "entry of a scalar", options: map[string]string{"test_name.x": "x"}, wantErr: `unknown option "test_name.x"`},
+		{name: "invalid int", options: map[string]string{"test_size": "big"}, wantErr: "expected an integer"},
+		{name: "invalid bool", options: map[string]string{"test_enabled": "on"}, wantErr: "expected true or false"},
+		{name: "map without key", options: map[string]string{"test_labels": "x"}, wantErr: "expected test_labels.<key>"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := testOptions{Name: "default"}
+			loc := &Location{Kind: "test", Options: tc.options}
+			err := loc.Decode(&got)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("options mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	got, err := describe(&testOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var flags []string
+	for _, opt := range got {
+		flags = append(flags, opt.Flag)
+	}
+	if diff := cmp.Diff([]string{"test-name", "test-size", "test-enabled", "test-label"}, flags); diff != "" {
+		t.Errorf("flags mismatch (-want +got):\n%s", diff)
+	}
+	labels := got[3]
+	if labels.Sep != ":" || labels.Source || !labels.Destination {
+		t.Errorf("expected a destination only map option separated by \":\", got %+v", labels)
+	}
+
+	if _, err := describe(&struct {
+		Sizes []int `json:"sizes"`
+	}{}); err == nil || !strings.Contains(err.Error(), "unsupported type") {
+		t.Errorf("expected an unsupported type error, got: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// Backend creates the Pullers and Pushers for a location kind
+type Backend struct {
+	// NewPuller is nil if the kind can't be used as a source. ctx is the
+	// run's, it only needs to be used for setting the backend up.
+	NewPuller func(ctx context.Context, loc *Location) (Puller, error)
+	// NewPusher is nil if the kind can't be used as a destination
+	NewPusher func(ctx context.Context, loc *Location) (Pusher, error)
+
+	// Options points to the struct the backend decodes a location's Options
+	// into, see Option for the struct tags it reads. Locations with options
+	// it has no field for fail validation. It may be nil if the backend has
+	// no options.
+	Options any
+	// AnyOptions accepts options Options has no field for, for backends
+	// that pass options on
+	AnyOptions bool
+	// Validate checks the options the backend requires, it may be nil
+	Validate func(loc *Location) error
+}
+
+type registered struct {
+	Backend
+	options []Option
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]registered{}
+)
+
+// Register makes kind usable as a source and/or destination. It panics if
+// kind is already registered or b.Options isn't a valid options struct, so
+// it is usually called from an init function.
+func Register(kind string, b Backend) {
+	var options []Option
+	if b.Options != nil {
+		var err error
+		if options, err = describe(b.Options); err != nil {
+			panic(fmt.Sprintf("backend: %s: %v", kind, err))
+		}
+	}
+
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, ok := backends[kind]; ok {
+		panic(fmt.Sprintf("backend: %q registered twice", kind))
+	}
+	backends[kind] = registered{Backend: b, options: options}
+}
+
+// Lookup returns the backend registered for kind
+func Lookup(kind string) (Backend, bool) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	b, ok := backends[kind]
+	return b.Backend, ok
+}
+
+// Kinds returns every registered kind in order
+func Kinds() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	return slices.Sorted(maps.Keys(backends))
+}
+
+// Describe returns the options of the backend registered for kind
+func Describe(kind string) []Option {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	return backends[kind].options
+}
+
+// Check validates loc's options against the backend registered for its kind
+func Check(loc *Location) error {
+	b, ok := Lookup(loc.Kind)
+	if !ok {
+		return fmt.Errorf("unknown kind %q", loc.Kind)
+	}
+	if b.Options != nil {
+		// Decode into a fresh struct so the registered one stays zero
+		opts := reflect.New(reflect.TypeOf(b.Options).Elem()).Interface()
+		if err := decode(loc.Options, opts, !b.AnyOptions); err != nil {
+			return fmt.Errorf("%s: %w", loc.Kind, err)
+		}
+	} else if !b.AnyOptions {
+		for key := range loc.Options {
+			return fmt.Errorf("%s: unknown option %q", loc.Kind, key)
+		}
+	}
+	if b.Validate == nil {
+		return nil
+	}
+	return b.Validate(loc)
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"flag"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/jacobmiller22/volume-backup/internal/config"
 	"github.com/jacobmiller22/volume-backup/internal/volback"
@@ -12,6 +16,20 @@ import (
 
 func main() {
 
+	// Interrupts and docker stop cancel the run, so uploads in progress are
+	// aborted and post hooks still run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 && os.Args[1] == "replicate" {
+		replicate(ctx, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "abort-uploads" {
+		abortUploads(ctx, os.Args[2:])
+		return
+	}
+
 	cfg, err := config.NewConfigLoader().WithFlagSet(flag.CommandLine, os.Args[1:]).Load()
 	if err != nil {
 		log.Fatalf("Error loading config: %v\n", err)
@@ -20,22 +38,112 @@ func main() {
 	if err := cfg.Validate(); err != nil {
 		log.Fatalf("Configuration error: %v\n", err)
 	}
+	ctx, cancel := withTimeout(ctx, cfg)
+	defer cancel()
 
-	executor, err := volback.NewExecutorFromConfig(cfg)
+	executor, err := volback.NewExecutorFromConfig(ctx, cfg)
 	if err != nil {
 		log.Fatalf("Error setting up Executor: %s\n", err)
 	}
 
+	var result *volback.Result
 	if cfg.Restore {
-		if err := executor.Restore(); err != nil {
-			log.Printf("Something went wrong while restoring path: %s; %v\n", cfg.Source.Path, err)
-			os.Exit(1)
-		}
+		result, err = executor.Restore(ctx)
 	} else {
-		if err := executor.Backup(); err != nil {
+		result, err = executor.Backup(ctx)
+	}
+
+	// A failed run still reports how far each destination got
+	if result != nil {
+		for _, d := range result.Destinations {
+			if d.Err != nil {
+				log.Printf("Destination %s %s failed: %v\n", d.Kind, d.Path, d.Err)
+			} else {
+				log.Printf("Destination %s %s succeeded\n", d.Kind, d.Path)
+			}
+		}
+		for _, w := range result.Warnings {
+			log.Printf("Warning: %v\n", w)
+		}
+	}
+
+	if err != nil {
+		if cfg.Restore {
+			log.Printf("Something went wrong while restoring path: %s; %v\n", cfg.Source.Path, err)
+		} else {
 			log.Printf("Something went wrong while backing up path: %s; %v\n", cfg.Source.Path, err)
-			os.Exit(1)
 		}
+		os.Exit(1)
+	}
+	if len(result.Warnings) > 0 {
+		log.Printf("Completed with %d warning(s)\n", len(result.Warnings))
+	}
+
+}
+
+// withTimeout cancels ctx once the configured timeout, which has been
+// validated, has passed
+func withTimeout(ctx context.Context, cfg *config.Config) (context.Context, context.CancelFunc) {
+	if cfg.Timeout == "" {
+		return ctx, func() {}
 	}
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+	return context.WithTimeout(ctx, timeout)
+}
+
+// replicate copies the backups below --src.path to --dst.path as they are
+func replicate(ctx context.Context, args []string) {
 
+	flagset := flag.NewFlagSet("replicate", flag.ExitOnError)
+	cfg, err := config.NewConfigLoader().WithFlagSet(flagset, args).Load()
+	if err != nil {
+		log.Fatalf("Error loading config: %v\n", err)
+	}
+
+	if err := cfg.ValidateReplicate(); err != nil {
+		log.Fatalf("Configuration error: %v\n", err)
+	}
+	ctx, cancel := withTimeout(ctx, cfg)
+	defer cancel()
+
+	replicator, err := volback.NewReplicatorFromConfig(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Error setting up Replicator: %s\n", err)
+	}
+
+	result, err := replicator.Replicate(ctx)
+	if result != nil {
+		log.Printf("Replicated %d object(s), skipped %d\n", len(result.Copied), len(result.Skipped))
+	}
+	if err != nil {
+		log.Printf("Something went wrong while replicating path: %s; %v\n", cfg.Source.Path, err)
+		os.Exit(1)
+	}
+}
+
+// abortUploads aborts multipart uploads below --dst.path that were abandoned
+func abortUploads(ctx context.Context, args []string) {
+
+	flagset := flag.NewFlagSet("abort-uploads", flag.ExitOnError)
+	olderThan := flagset.Duration("older-than", 24*time.Hour, "Only abort uploads started longer ago than this")
+	cfg, err := config.NewConfigLoader().WithFlagSet(flagset, args).Load()
+	if err != nil {
+		log.Fatalf("Error loading config: %v\n", err)
+	}
+
+	if err := cfg.ValidateDestination(); err != nil {
+		log.Fatalf("Configuration error: %v\n", err)
+	}
+	ctx, cancel := withTimeout(ctx, cfg)
+	defer cancel()
+
+	aborted, err := volback.AbortStaleUploadsFromConfig(ctx, cfg, *olderThan)
+	for _, key := range aborted {
+		log.Printf("Aborted upload of %s\n", key)
+	}
+	if err != nil {
+		log.Printf("Something went wrong while aborting uploads below path: %s; %v\n", cfg.Destination.Path, err)
+		os.Exit(1)
+	}
+	log.Printf("Aborted %d upload(s)\n", len(aborted))
 }
@@ -8,6 +8,7 @@ import boto3
 import secrets
 import botocore.client
 import testcontainers.localstack
+import testcontainers.azurite
 import pathlib
 import pytest
 import subprocess
@@ -67,6 +68,19 @@ def s3client(lsendpointurl):
     return client
 
 
+@pytest.fixture(scope="session")
+def azurite():
+    with testcontainers.azurite.AzuriteContainer() as azurite:
+        yield azurite
+
+
+@pytest.fixture(scope="session")
+def azurite_blob_endpoint(azurite):
+    host = azurite.get_container_host_ip()
+    port = azurite.get_exposed_port(azurite.blob_service_port)
+    return f"http://{host}:{port}/{azurite.account_name}"
+
+
 @pytest.fixture(scope="function")
 def cleanup_testdata():
     yield
@@ -316,3 +330,73 @@ def test_fs2fs_directory(cleanup_testdata):
     assert original_path.is_dir()
     assert encrypted_path.is_file()
     assert restored_path.is_dir()
+
+
+def test_e2e_fs2azblob(cleanup_testdata, azurite, azurite_blob_endpoint):
+    """
+    This test will backup a file to Azurite, and restore the file and confirm nothing was lost.
+
+    Source: fs
+    Destination: azblob
+    """
+
+    k = encryption_key(19)
+
+    original_path = E2E_ROOT_PATH.joinpath("./testdata/lorem.pt")
+    encrypted_path = "testdata/generated/lorem-3.pt.ct"
+    restored_path = E2E_ROOT_PATH.joinpath("./testdata/generated/lorem-3.pt.ct.pt")
+
+    def azblob_args(prefix: str):
+        return [
+            f"-{prefix}.azblob-account",
+            azurite.account_name,
+            f"-{prefix}.azblob-account-key",
+            azurite.account_key,
+            f"-{prefix}.azblob-container",
+            "backups",
+            f"-{prefix}.azblob-endpoint",
+            azurite_blob_endpoint,
+        ]
+
+    cp = subprocess.run(
+        [
+            BIN_PATH,
+            "-src.kind",
+            "fs",
+            "-src.path",
+            original_path.absolute().as_posix(),
+            "--enc.key",
+            k,
+            "-dst.kind",
+            "azblob",
+            "-dst.path",
+            encrypted_path,
+            "-dst.azblob-create-container",
+            *azblob_args("dst"),
+        ]
+    )
+    assert cp.returncode == 0
+
+    cp = subprocess.run(
+        [
+            BIN_PATH,
+            "-src.kind",
+            "azblob",
+            "-src.path",
+            encrypted_path,
+            *azblob_args("src"),
+            "--restore=true",
+            "--enc.key",
+            k,
+            "-dst.kind",
+            "fs",
+            "-dst.path",
+            restored_path.absolute().as_posix(),
+        ]
+    )
+    assert cp.returncode == 0
+
+    original_data = open(original_path, "rb").read()
+    restored_data = open(restored_path.joinpath("lorem.pt"), "rb").read()
+
+    assert original_data == restored_data
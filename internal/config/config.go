@@ -1,13 +1,20 @@
 package config
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"maps"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jacobmiller22/volume-backup/backend"
 	"github.com/sethvargo/go-envconfig"
 )
 
@@ -31,11 +38,18 @@ func ConfigFromFlagset(flagset *flag.FlagSet, args []string) (*Config, error) {
 	flagset.StringVar(&cfg.JsonConfigPath, "f", "", "Path to volback configuration file")
 	flagset.StringVar(&cfg.Source.Kind, "src.kind", "", "the type of source")
 	flagset.StringVar(&cfg.Source.Path, "src.path", "", "Path to a folder or file to backup.")
-	flagset.StringVar(&cfg.Source.S3_Endpoint, "src.s3-endpoint", "", "Hostname to use as an endpoint for s3 compatible storage")
-	flagset.StringVar(&cfg.Source.S3_Bucket, "src.s3-bucket", "", "Name of the bucket to source from")
-	flagset.StringVar(&cfg.Source.S3_AccessKeyId, "src.s3-access-key-id", "", "The access key id")
-	flagset.StringVar(&cfg.Source.S3_SecretAccessKey, "src.s3-secret-access-key", "", "The secret access key")
-	flagset.StringVar(&cfg.Source.S3_Region, "src.s3-region", "", "The secret access key")
+	backendFlags(flagset, "src.", &cfg.Source.Options, false)
+	flagset.Var(mapFlag{&cfg.Source.Options, "="}, "src.option", "Backend specific option as \"key=value\", may be repeated")
+	flagset.StringVar(&cfg.Source.RequestTimeout, "src.request-timeout", "", "Time limit for each attempt at an operation, e.g. 2m")
+	flagset.IntVar(&cfg.Source.Retries, "src.retries", 0, "Number of times a failed operation is retried")
+	flagset.StringVar(&cfg.Source.RetryBackoff, "src.retry-backoff", "", "Delay before the first retry, doubled for each one after it, e.g. 1s")
+	flagset.StringVar(&cfg.Source.RetryMaxBackoff, "src.retry-max-backoff", "", "Longest delay between retries, e.g. 1m")
+
+	flagset.StringVar(&cfg.Timeout, "timeout", "", "Time limit for the whole run, e.g. 6h")
+	flagset.StringVar(&cfg.Progress, "progress", "", "How to report progress on stderr: text, json or quiet")
+	flagset.StringVar(&cfg.ProgressInterval, "progress-interval", "", "How often to report progress, e.g. 30s")
+
+	flagset.StringVar(&cfg.DestinationPolicy, "dst.policy", "", "Whether all destinations must succeed, or just any one of them: all or any")
 
 	flagset.BoolVar(&cfg.Restore, "restore", false, "If we should restore a backup")
 
@@ -43,11 +57,15 @@ func ConfigFromFlagset(flagset *flag.FlagSet, args []string) (*Config, error) {
 
 	flagset.StringVar(&cfg.Destination.Kind, "dst.kind", "", "the type of destination")
 	flagset.StringVar(&cfg.Destination.Path, "dst.path", "", "Path to place backup")
-	flagset.StringVar(&cfg.Destination.S3_Endpoint, "dst.s3-endpoint", "", "Hostname to use as an endpoint for s3 compatible storage")
-	flagset.StringVar(&cfg.Destination.S3_Bucket, "dst.s3-bucket", "", "Name of the bucket to backup to")
-	flagset.StringVar(&cfg.Destination.S3_AccessKeyId, "dst.s3-access-key-id", "", "The access key id")
-	flagset.StringVar(&cfg.Destination.S3_SecretAccessKey, "dst.s3-secret-access-key", "", "The secret access key")
-	flagset.StringVar(&cfg.Destination.S3_Region, "dst.s3-region", "", "The secret access key")
+	backendFlags(flagset, "dst.", &cfg.Destination.Options, true)
+	flagset.Var(mapFlag{&cfg.Destination.Options, "="}, "dst.option", "Backend specific option as \"key=value\", may be repeated")
+	flagset.StringVar(&cfg.Destination.RequestTimeout, "dst.request-timeout", "", "Time limit for each attempt at an operation, e.g. 2m")
+	flagset.IntVar(&cfg.Destination.Retries, "dst.retries", 0, "Number of times a failed operation is retried")
+	flagset.StringVar(&cfg.Destination.RetryBackoff, "dst.retry-backoff", "", "Delay before the first retry, doubled for each one after it, e.g. 1s")
+	flagset.StringVar(&cfg.Destination.RetryMaxBackoff, "dst.retry-max-backoff", "", "Longest delay between retries, e.g. 1m")
+	flagset.IntVar(&cfg.Destination.BandwidthLimit, "dst.bandwidth-limit", 0, "Bytes per second to push at most, 0 for no limit")
+	flagset.IntVar(&cfg.Destination.BandwidthBurst, "dst.bandwidth-burst", 0, "Bytes that can be pushed at once above the limit, defaults to a second's worth")
+	flagset.StringVar(&cfg.Destination.BandwidthWindow, "dst.bandwidth-window", "", "Local times of day the limit applies between, e.g. 09:00-17:00")
 
 	if err := flagset.Parse(args); err != nil {
 		return nil, err
@@ -124,19 +142,131 @@ func (cl *configLoader) Load() (*Config, error) {
 	return mergeConfigs(configs...), nil
 }
 
-type S3location struct {
-	S3_AccessKeyId     string `json:"s3_access_key_id"`
-	S3_SecretAccessKey string `json:"s3_secret_access_key"`
-	S3_Endpoint        string `json:"s3_endpoint"`
-	S3_Bucket          string `json:"s3_bucket"`
-	S3_Region          string `json:"s3_region"`
+// RetryLocation configures how failed operations on a location are retried
+type RetryLocation struct {
+	// RequestTimeout bounds each attempt at an operation, e.g. "2m". It
+	// doesn't bound streaming an object, only how long a pull takes to start,
+	// and pushes have no per-request timeout. S3 also uses it to bound how
+	// long each request waits for a response.
+	RequestTimeout string `json:"request_timeout"`
+	// Retries is how many times a failed operation is retried. The first
+	// retry waits RetryBackoff, e.g. "1s", and each one after it waits twice
+	// as long as the last, up to RetryMaxBackoff
+	Retries         int    `json:"retries"`
+	RetryBackoff    string `json:"retry_backoff"`
+	RetryMaxBackoff string `json:"retry_max_backoff"`
+}
+
+// ThrottleLocation limits how fast backups are pushed to a location
+type ThrottleLocation struct {
+	// BandwidthLimit is in bytes per second, 0 for no limit. Up to
+	// BandwidthBurst bytes, by default a second's worth, can be sent at once
+	BandwidthLimit int `json:"bandwidth_limit"`
+	BandwidthBurst int `json:"bandwidth_burst"`
+	// BandwidthWindow only applies the limit between two local times of day,
+	// e.g. "09:00-17:00", which may wrap past midnight. The limit always
+	// applies if it isn't set
+	BandwidthWindow string `json:"bandwidth_window"`
 }
 
 type Location struct {
 	Kind string `json:"kind"`
 	Path string `json:"path"`
 
-	S3location
+	RetryLocation
+	ThrottleLocation
+
+	// Options configure the location's backend, which decodes them itself.
+	// In json they are set alongside kind and path, or in an options object.
+	// Map options such as http_headers are stored as one "<name>.<key>"
+	// option per entry.
+	Options map[string]string `json:"options"`
+}
+
+// UnmarshalJSON reads every key that isn't a field of Location into Options
+func (l *Location) UnmarshalJSON(data []byte) error {
+	// plain has Location's fields but not this method
+	type plain Location
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	fields := map[string]bool{}
+	for _, f := range reflect.VisibleFields(reflect.TypeFor[plain]()) {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		fields[name] = true
+	}
+	for key, raw := range keys {
+		if fields[key] {
+			continue
+		}
+		if p.Options == nil {
+			p.Options = map[string]string{}
+		}
+		if err := setJsonOption(p.Options, key, raw); err != nil {
+			return err
+		}
+	}
+	*l = Location(p)
+	return nil
+}
+
+// setJsonOption stores a json string, number, bool or object of them in
+// opts. Objects are stored as one "<key>.<name>" option per entry.
+func setJsonOption(opts map[string]string, key string, raw json.RawMessage) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+	case string:
+		opts[key] = v
+	case json.Number:
+		opts[key] = v.String()
+	case bool:
+		opts[key] = strconv.FormatBool(v)
+	case map[string]any:
+		for name, value := range v {
+			switch value.(type) {
+			case string, json.Number, bool:
+				opts[key+"."+name] = fmt.Sprint(value)
+			default:
+				return fmt.Errorf("%s.%s must be a string, number or boolean", key, name)
+			}
+		}
+	default:
+		return fmt.Errorf("%s must be a string, number, boolean or object", key)
+	}
+	return nil
+}
+
+// Hook is a shell command run around a backup or restore
+type Hook struct {
+	Command string `json:"command"`
+	// Timeout is a duration such as "30s". Hooks have no timeout by default
+	Timeout string `json:"timeout"`
+	// ContinueOnError stops a failing pre hook from aborting the run
+	ContinueOnError bool `json:"continue_on_error"`
+}
+
+// Hooks are run by the executor. Post hooks run whether or not the operation
+// succeeded, so they can undo what the pre hooks did, with VOLBACK_STATUS set
+// to success, failure or aborted if a pre hook failed; OnFailure hooks run
+// afterwards if anything failed.
+type Hooks struct {
+	PreBackup   []Hook `json:"pre_backup"`
+	PostBackup  []Hook `json:"post_backup"`
+	PreRestore  []Hook `json:"pre_restore"`
+	PostRestore []Hook `json:"post_restore"`
+	OnFailure   []Hook `json:"on_failure"`
 }
 
 type Config struct {
@@ -148,9 +278,120 @@ type Config struct {
 	} `json:"encryption"`
 	Destination Location `json:"destination"`
 
+	// Destinations are pushed to alongside Destination by the same run, so
+	// the source is only archived and encrypted once. They can only be
+	// configured from json
+	Destinations []Location `json:"destinations"`
+	// DestinationPolicy is "all" to fail the run if any destination fails,
+	// or "any" to only fail it if every destination fails. Defaults to "all"
+	DestinationPolicy string `json:"destination_policy"`
+
+	// Hooks can only be configured from json
+	Hooks Hooks `json:"hooks"`
+
+	// Timeout bounds the whole run, e.g. "6h". Post hooks still run once it
+	// has passed
+	Timeout string `json:"timeout"`
+
+	// Progress is how backups and restores report progress on stderr: "text"
+	// lines, "json" lines for other programs, or "quiet". Defaults to "text"
+	Progress string `json:"progress"`
+	// ProgressInterval is how often progress is reported, e.g. "30s".
+	// Defaults to 10s
+	ProgressInterval string `json:"progress_interval"`
+
 	S3ForcePathStyle bool `env:"S3_FORCE_PATH_STYLE,default=false"`
 }
 
+// mapFlag is a repeatable flag.Value that collects "key<sep>value" pairs
+type mapFlag struct {
+	m   *map[string]string
+	sep string
+}
+
+func (f mapFlag) String() string {
+	if f.m == nil {
+		return ""
+	}
+	return fmt.Sprint(*f.m)
+}
+
+func (f mapFlag) Set(value string) error {
+	k, v, ok := strings.Cut(value, f.sep)
+	if !ok {
+		return fmt.Errorf("expected key%svalue, got %q", f.sep, value)
+	}
+	if *f.m == nil {
+		*f.m = map[string]string{}
+	}
+	(*f.m)[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	return nil
+}
+
+// backendFlags defines a flag for every option of the registered backends
+// that applies to sources, or to destinations if dst is set. The flags set
+// opts, prefix is prepended to their names.
+func backendFlags(flagset *flag.FlagSet, prefix string, opts *map[string]string, dst bool) {
+	for _, kind := range backend.Kinds() {
+		for _, opt := range backend.Describe(kind) {
+			if dst && !opt.Destination || !dst && !opt.Source {
+				continue
+			}
+			// Backends may share options
+			if flagset.Lookup(prefix+opt.Flag) != nil {
+				continue
+			}
+			flagset.Var(optionFlag{opts, opt}, prefix+opt.Flag, opt.Usage)
+		}
+	}
+}
+
+// optionFlag is a flag.Value that sets a backend option. Map options are
+// repeatable flags that collect "key<sep>value" pairs.
+type optionFlag struct {
+	m   *map[string]string
+	opt backend.Option
+}
+
+func (f optionFlag) String() string {
+	if f.m == nil {
+		return ""
+	}
+	return (*f.m)[f.opt.Name]
+}
+
+func (f optionFlag) Set(value string) error {
+	key := f.opt.Name
+	switch f.opt.Kind {
+	case reflect.Int:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		value = strconv.FormatBool(b)
+	case reflect.Map:
+		k, v, ok := strings.Cut(value, f.opt.Sep)
+		if !ok {
+			return fmt.Errorf("expected key%svalue, got %q", f.opt.Sep, value)
+		}
+		key, value = key+"."+strings.TrimSpace(k), strings.TrimSpace(v)
+	}
+	if *f.m == nil {
+		*f.m = map[string]string{}
+	}
+	(*f.m)[key] = value
+	return nil
+}
+
+// IsBoolFlag lets bool options be set without a value
+func (f optionFlag) IsBoolFlag() bool {
+	return f.opt.Kind == reflect.Bool
+}
+
 // Merges the provided configs
 // Configs provided later have high priority
 func mergeConfigs(configs ...*Config) *Config {
@@ -168,11 +409,11 @@ func mergeConfigs(configs ...*Config) *Config {
 
 		C.Source.Kind = weakAssign(C.Source.Kind, c.Source.Kind)
 		C.Source.Path = weakAssign(C.Source.Path, c.Source.Path)
-		C.Source.S3_AccessKeyId = weakAssign(C.Source.S3_AccessKeyId, c.Source.S3_AccessKeyId)
-		C.Source.S3_SecretAccessKey = weakAssign(C.Source.S3_SecretAccessKey, c.Source.S3_SecretAccessKey)
-		C.Source.S3_Endpoint = weakAssign(C.Source.S3_Endpoint, c.Source.S3_Endpoint)
-		C.Source.S3_Bucket = weakAssign(C.Source.S3_Bucket, c.Source.S3_Bucket)
-		C.Source.S3_Region = weakAssign(C.Source.S3_Region, c.Source.S3_Region)
+		C.Source.Options = mergeOptions(C.Source.Options, c.Source.Options)
+		C.Source.RequestTimeout = weakAssign(C.Source.RequestTimeout, c.Source.RequestTimeout)
+		C.Source.Retries = weakAssign(C.Source.Retries, c.Source.Retries)
+		C.Source.RetryBackoff = weakAssign(C.Source.RetryBackoff, c.Source.RetryBackoff)
+		C.Source.RetryMaxBackoff = weakAssign(C.Source.RetryMaxBackoff, c.Source.RetryMaxBackoff)
 
 		C.Restore = weakAssign(C.Restore, c.Restore)
 
@@ -180,11 +421,20 @@ func mergeConfigs(configs ...*Config) *Config {
 
 		C.Destination.Kind = weakAssign(C.Destination.Kind, c.Destination.Kind)
 		C.Destination.Path = weakAssign(C.Destination.Path, c.Destination.Path)
-		C.Destination.S3_AccessKeyId = weakAssign(C.Destination.S3_AccessKeyId, c.Destination.S3_AccessKeyId)
-		C.Destination.S3_SecretAccessKey = weakAssign(C.Destination.S3_SecretAccessKey, c.Destination.S3_SecretAccessKey)
-		C.Destination.S3_Endpoint = weakAssign(C.Destination.S3_Endpoint, c.Destination.S3_Endpoint)
-		C.Destination.S3_Bucket = weakAssign(C.Destination.S3_Bucket, c.Destination.S3_Bucket)
-		C.Destination.S3_Region = weakAssign(C.Destination.S3_Region, c.Destination.S3_Region)
+		C.Destination.Options = mergeOptions(C.Destination.Options, c.Destination.Options)
+		C.Destination.RequestTimeout = weakAssign(C.Destination.RequestTimeout, c.Destination.RequestTimeout)
+		C.Destination.Retries = weakAssign(C.Destination.Retries, c.Destination.Retries)
+		C.Destination.RetryBackoff = weakAssign(C.Destination.RetryBackoff, c.Destination.RetryBackoff)
+		C.Destination.RetryMaxBackoff = weakAssign(C.Destination.RetryMaxBackoff, c.Destination.RetryMaxBackoff)
+		C.Destination.BandwidthLimit = weakAssign(C.Destination.BandwidthLimit, c.Destination.BandwidthLimit)
+		C.Destination.BandwidthBurst = weakAssign(C.Destination.BandwidthBurst, c.Destination.BandwidthBurst)
+		C.Destination.BandwidthWindow = weakAssign(C.Destination.BandwidthWindow, c.Destination.BandwidthWindow)
+
+		C.DestinationPolicy = weakAssign(C.DestinationPolicy, c.DestinationPolicy)
+
+		C.Timeout = weakAssign(C.Timeout, c.Timeout)
+		C.Progress = weakAssign(C.Progress, c.Progress)
+		C.ProgressInterval = weakAssign(C.ProgressInterval, c.ProgressInterval)
 
 		C.S3ForcePathStyle = weakAssign(C.S3ForcePathStyle, c.S3ForcePathStyle)
 	}
@@ -201,16 +451,220 @@ func weakAssign[T string | int | bool](a, b T) T {
 	return b
 }
 
+// return a with the options in b set over it
+func mergeOptions(a, b map[string]string) map[string]string {
+	if len(b) == 0 {
+		return a
+	}
+	merged := maps.Clone(a)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	maps.Copy(merged, b)
+	return merged
+}
+
 func (c *Config) Validate() error {
 	if c.Source.Kind == "" {
 		return fmt.Errorf("source kind is required")
 	}
-	if c.Destination.Kind == "" {
+	if c.Destination.Kind == "" && len(c.Destinations) == 0 {
 		return fmt.Errorf("destination kind is required")
 	}
 	if c.Encryption.Key == "" {
 		return fmt.Errorf("encryption key is required")
 	}
+	if err := c.validateTimeout(); err != nil {
+		return err
+	}
+	if err := c.Source.validate(c.Restore); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	if c.Destination.Kind != "" {
+		if err := c.Destination.validate(c.Restore); err != nil {
+			return fmt.Errorf("destination: %w", err)
+		}
+	}
+	for i, loc := range c.Destinations {
+		if loc.Kind == "" {
+			return fmt.Errorf("destinations[%d]: kind is required", i)
+		}
+		if err := loc.validate(c.Restore); err != nil {
+			return fmt.Errorf("destinations[%d]: %w", i, err)
+		}
+	}
+	switch c.DestinationPolicy {
+	case "", "all", "any":
+	default:
+		return fmt.Errorf("invalid destination_policy %q, must be one of all or any", c.DestinationPolicy)
+	}
+	switch c.Progress {
+	case "", "text", "json", "quiet":
+	default:
+		return fmt.Errorf("invalid progress %q, must be one of text, json or quiet", c.Progress)
+	}
+	if c.ProgressInterval != "" {
+		d, err := time.ParseDuration(c.ProgressInterval)
+		if err != nil {
+			return fmt.Errorf("invalid progress_interval: %w", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("progress_interval must be positive")
+		}
+	}
+	for name, hooks := range map[string][]Hook{
+		"pre_backup":   c.Hooks.PreBackup,
+		"post_backup":  c.Hooks.PostBackup,
+		"pre_restore":  c.Hooks.PreRestore,
+		"post_restore": c.Hooks.PostRestore,
+		"on_failure":   c.Hooks.OnFailure,
+	} {
+		for i, hook := range hooks {
+			if hook.Command == "" {
+				return fmt.Errorf("hooks.%s[%d]: command is required", name, i)
+			}
+			if hook.Timeout == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(hook.Timeout); err != nil {
+				return fmt.Errorf("hooks.%s[%d]: invalid timeout: %w", name, i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateReplicate checks the config for replicating backups from the
+// source to the destination, which needs no encryption key
+func (c *Config) ValidateReplicate() error {
+	if c.Source.Kind == "" {
+		return fmt.Errorf("source kind is required")
+	}
+	if c.Destination.Kind == "" {
+		return fmt.Errorf("destination kind is required")
+	}
+	if len(c.Destinations) > 0 {
+		return fmt.Errorf("only a single destination can be replicated to")
+	}
+	if err := c.validateTimeout(); err != nil {
+		return err
+	}
+	if err := c.Source.validate(c.Restore); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	if err := c.Destination.validate(c.Restore); err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+	return nil
+}
+
+// ValidateDestination checks the config for commands that only operate on
+// the destination
+func (c *Config) ValidateDestination() error {
+	if c.Destination.Kind == "" {
+		return fmt.Errorf("destination kind is required")
+	}
+	if err := c.validateTimeout(); err != nil {
+		return err
+	}
+	if err := c.Destination.validate(c.Restore); err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+	return nil
+}
+
+func (c *Config) validateTimeout() error {
+	if c.Timeout == "" {
+		return nil
+	}
+	if _, err := time.ParseDuration(c.Timeout); err != nil {
+		return fmt.Errorf("invalid timeout: %w", err)
+	}
+	return nil
+}
+
+// AllDestinations returns Destination, if it is set, followed by Destinations
+func (c *Config) AllDestinations() []Location {
+	var locs []Location
+	if c.Destination.Kind != "" {
+		locs = append(locs, c.Destination)
+	}
+	return append(locs, c.Destinations...)
+}
+
+// validate checks the location's retry and throttle settings, and has its
+// backend check its options. restore is set if a backup is being restored.
+func (l *Location) validate(restore bool) error {
+	if err := l.RetryLocation.validate(); err != nil {
+		return err
+	}
+	if err := l.ThrottleLocation.validate(); err != nil {
+		return err
+	}
+	return backend.Check(&backend.Location{Kind: l.Kind, Path: l.Path, Restore: restore, Options: l.Options})
+}
 
+func (r *RetryLocation) validate() error {
+	if r.Retries < 0 {
+		return fmt.Errorf("retries can't be negative")
+	}
+	for name, d := range map[string]string{
+		"request_timeout":   r.RequestTimeout,
+		"retry_backoff":     r.RetryBackoff,
+		"retry_max_backoff": r.RetryMaxBackoff,
+	} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
 	return nil
 }
+
+func (t *ThrottleLocation) validate() error {
+	if t.BandwidthLimit < 0 || t.BandwidthBurst < 0 {
+		return fmt.Errorf("bandwidth_limit and bandwidth_burst can't be negative")
+	}
+	if t.BandwidthLimit == 0 && (t.BandwidthBurst != 0 || t.BandwidthWindow != "") {
+		return fmt.Errorf("bandwidth_limit is required with bandwidth_burst or bandwidth_window")
+	}
+	if t.BandwidthWindow == "" {
+		return nil
+	}
+	if _, _, err := ParseTimeWindow(t.BandwidthWindow); err != nil {
+		return fmt.Errorf("invalid bandwidth_window: %w", err)
+	}
+	return nil
+}
+
+// ParseTimeWindow parses a window between two times of day, such as
+// "09:00-17:00", into how long after midnight it starts and ends. The end is
+// before the start if the window wraps past midnight.
+func ParseTimeWindow(s string) (start, end time.Duration, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected HH:MM-HH:MM, got %q", s)
+	}
+	if start, err = parseTimeOfDay(from); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseTimeOfDay(to); err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("window %q is empty", s)
+	}
+	return start, end, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into how long after midnight it is
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -1,13 +1,42 @@
 package config
 
 import (
+	"encoding/json"
 	"flag"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/jacobmiller22/volume-backup/backend"
 )
 
+// testOptions are the options of the test kind
+type testOptions struct {
+	Endpoint string            `json:"test_endpoint"`
+	Port     int               `json:"test_port"`
+	Verbose  bool              `json:"test_verbose"`
+	Headers  map[string]string `json:"test_headers" flag:"test-header" sep:":"`
+}
+
+// s3Options stand in for the options of the s3 kind, which lives in a
+// package this one can't import
+type s3Options struct {
+	S3_AccessKeyId     string `json:"s3_access_key_id"`
+	S3_SecretAccessKey string `json:"s3_secret_access_key"`
+	S3_Endpoint        string `json:"s3_endpoint"`
+	S3_Bucket          string `json:"s3_bucket"`
+	S3_Region          string `json:"s3_region"`
+}
+
+func init() {
+	// Kinds for tests that load and validate whole configs
+	backend.Register("test", backend.Backend{Options: &testOptions{}})
+	backend.Register("fs", backend.Backend{})
+	backend.Register("s3", backend.Backend{Options: &s3Options{}})
+}
+
 func TestConfigFromEnv(t *testing.T) {
 	// Set environment variable for testing
 	os.Setenv("S3_FORCE_PATH_STYLE", "true")
@@ -72,8 +101,8 @@ func TestNewConfig(t *testing.T) {
 		Source: Location{
 			Kind: "fs",
 			Path: "Makefile",
-			S3location: S3location{
-				S3_Endpoint: "overridden-endpoint",
+			Options: map[string]string{
+				"s3_endpoint": "overridden-endpoint",
 			},
 		},
 		Restore: false,
@@ -85,12 +114,12 @@ func TestNewConfig(t *testing.T) {
 		Destination: Location{
 			Kind: "s3",
 			Path: "testing/backups/Makefile.backup",
-			S3location: S3location{
-				S3_AccessKeyId:     "keyid",
-				S3_SecretAccessKey: "secretkey",
-				S3_Endpoint:        "s3.us-east-005.backblazeb2.com",
-				S3_Bucket:          "jacobmiller22-secure-backup",
-				S3_Region:          "us-east-1",
+			Options: map[string]string{
+				"s3_access_key_id":     "keyid",
+				"s3_secret_access_key": "secretkey",
+				"s3_endpoint":          "s3.us-east-005.backblazeb2.com",
+				"s3_bucket":            "jacobmiller22-secure-backup",
+				"s3_region":            "us-east-1",
 			},
 		},
 	}
@@ -99,3 +128,242 @@ func TestNewConfig(t *testing.T) {
 		t.Errorf("Config mismatch (-expected +actual):\n%s", diff)
 	}
 }
+
+func TestNewConfig_Options(t *testing.T) {
+	var jsonData string = `
+		{
+			"source": {
+				"kind": "test",
+				"path": "Makefile",
+				"test_verbose": true
+			},
+			"restore": false,
+			"encryption": {
+				"key": "temp size 16 key"
+			},
+			"destination": {
+				"kind": "test",
+				"path": "testing/backups/Makefile.backup",
+				"test_endpoint": "s3.us-east-005.backblazeb2.com",
+				"test_port": 9000,
+				"test_headers": {"X-Api-Key": "abc"},
+				"retries": 3,
+				"options": {
+					"prefix": "p/"
+				}
+			}
+		}`
+
+	// Add to testing temp file
+	tmpFile := t.TempDir() + "/config.json"
+	err := os.WriteFile(tmpFile, []byte(jsonData), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write temp config file: %v", err)
+	}
+
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	//
+
+	loader := NewConfigLoader().WithFlagSet(flagset, []string{"-f", tmpFile, "-src.test-endpoint", "overridden-endpoint", "-src.test-verbose=false", "-dst.test-header", "X-Trace: on"})
+
+	cfg, err := loader.Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expectedCfg := &Config{
+		JsonConfigPath: tmpFile,
+		Source: Location{
+			Kind: "test",
+			Path: "Makefile",
+			Options: map[string]string{
+				"test_endpoint": "overridden-endpoint",
+				"test_verbose":  "false",
+			},
+		},
+		Restore: false,
+		Encryption: struct {
+			Key string `json:"key"`
+		}{
+			Key: "temp size 16 key",
+		},
+		Destination: Location{
+			Kind:          "test",
+			Path:          "testing/backups/Makefile.backup",
+			RetryLocation: RetryLocation{Retries: 3},
+			Options: map[string]string{
+				"test_endpoint":          "s3.us-east-005.backblazeb2.com",
+				"test_port":              "9000",
+				"test_headers.X-Api-Key": "abc",
+				"test_headers.X-Trace":   "on",
+				"prefix":                 "p/",
+			},
+		},
+	}
+
+	if diff := cmp.Diff(expectedCfg, cfg); diff != "" {
+		t.Errorf("Config mismatch (-expected +actual):\n%s", diff)
+	}
+}
+
+func TestLocation_UnmarshalJSON_Invalid(t *testing.T) {
+	for _, data := range []string{
+		`{"kind": "test", "test_headers": ["X-Api-Key"]}`,
+		`{"kind": "test", "test_headers": {"X-Api-Key": {"value": "abc"}}}`,
+	} {
+		var loc Location
+		if err := json.Unmarshal([]byte(data), &loc); err == nil || !strings.Contains(err.Error(), "test_headers") {
+			t.Errorf("expected an error about test_headers for %s, got: %v", data, err)
+		}
+	}
+}
+
+func TestConfigFromFlagset_Headers(t *testing.T) {
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	cfg, err := ConfigFromFlagset(flagset, []string{
+		"-dst.kind", "test",
+		"-dst.test-header", "X-Api-Key: abc",
+		"-dst.test-header", "X-Trace:on",
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	expected := map[string]string{"test_headers.X-Api-Key": "abc", "test_headers.X-Trace": "on"}
+	if diff := cmp.Diff(expected, cfg.Destination.Options); diff != "" {
+		t.Errorf("Headers mismatch (-expected +actual):\n%s", diff)
+	}
+}
+
+func TestThrottleLocation_Validate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		throttle ThrottleLocation
+		wantErr  string
+	}{
+		{name: "valid", throttle: ThrottleLocation{BandwidthLimit: 1 << 20, BandwidthBurst: 1 << 16, BandwidthWindow: "22:00-06:00"}},
+		{name: "window without limit", throttle: ThrottleLocation{BandwidthWindow: "09:00-17:00"}, wantErr: "bandwidth_limit is required"},
+		{name: "negative limit", throttle: ThrottleLocation{BandwidthLimit: -1}, wantErr: "can't be negative"},
+		{name: "invalid window", throttle: ThrottleLocation{BandwidthLimit: 1, BandwidthWindow: "9am-5pm"}, wantErr: "invalid bandwidth_window"},
+		{name: "empty window", throttle: ThrottleLocation{BandwidthLimit: 1, BandwidthWindow: "09:00-09:00"}, wantErr: "is empty"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.throttle.validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseTimeWindow(t *testing.T) {
+	testCases := []struct {
+		window    string
+		wantStart time.Duration
+		wantEnd   time.Duration
+		wantErr   string
+	}{
+		{window: "09:00-17:30", wantStart: 9 * time.Hour, wantEnd: 17*time.Hour + 30*time.Minute},
+		{window: "22:00 - 06:00", wantStart: 22 * time.Hour, wantEnd: 6 * time.Hour},
+		{window: "00:00-23:59", wantStart: 0, wantEnd: 23*time.Hour + 59*time.Minute},
+		{window: "09:00", wantErr: "expected HH:MM-HH:MM"},
+		{window: "9am-5pm", wantErr: "invalid time of day"},
+		{window: "09:00-24:00", wantErr: "invalid time of day"},
+		{window: "12:00-12:00", wantErr: "is empty"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.window, func(t *testing.T) {
+			start, end, err := ParseTimeWindow(tc.window)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if start != tc.wantStart || end != tc.wantEnd {
+				t.Errorf("expected %s-%s, got %s-%s", tc.wantStart, tc.wantEnd, start, end)
+			}
+		})
+	}
+}
+
+func TestProgress_Validate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		progress string
+		interval string
+		wantErr  string
+	}{
+		{name: "valid", progress: "json", interval: "30s"},
+		{name: "invalid format", progress: "verbose", wantErr: "invalid progress"},
+		{name: "invalid interval", interval: "often", wantErr: "invalid progress_interval"},
+		{name: "zero interval", interval: "0s", wantErr: "must be positive"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{
+				Source:           Location{Kind: "test", Path: "source"},
+				Destination:      Location{Kind: "test", Path: "backup"},
+				Progress:         tc.progress,
+				ProgressInterval: tc.interval,
+			}
+			cfg.Encryption.Key = "temp size 16 key"
+
+			err := cfg.Validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLocation_Validate_Options(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options map[string]string
+		wantErr string
+	}{
+		{name: "valid", options: map[string]string{"test_port": "22", "test_verbose": "true", "test_headers.X-Api-Key": "abc"}},
+		{name: "unknown option", options: map[string]string{"s3_bucket": "backups"}, wantErr: `unknown option "s3_bucket"`},
+		{name: "invalid int", options: map[string]string{"test_port": "ssh"}, wantErr: "expected an integer"},
+		{name: "invalid bool", options: map[string]string{"test_verbose": "yes please"}, wantErr: "expected true or false"},
+		{name: "map without key", options: map[string]string{"test_headers": "X-Api-Key"}, wantErr: "expected test_headers.<key>"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			loc := Location{Kind: "test", Path: "backup", Options: tc.options}
+			err := loc.validate(false)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
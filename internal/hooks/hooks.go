@@ -0,0 +1,62 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+
+	"github.com/jacobmiller22/volume-backup/internal/config"
+)
+
+// Run runs each hook in order with `sh -c`, adding env to the process
+// environment along with VOLBACK_HOOK=name. A failing hook stops the rest
+// from running unless it is marked ContinueOnError, in which case the failure
+// is only logged.
+func Run(ctx context.Context, name string, hooks []config.Hook, env []string) error {
+	for i, hook := range hooks {
+		log.Printf("Running %s hook: %s\n", name, hook.Command)
+
+		if err := run(ctx, hook, append(slices.Clone(env), "VOLBACK_HOOK="+name)); err != nil {
+			err = fmt.Errorf("%s hook %d: %w", name, i, err)
+			if hook.ContinueOnError {
+				log.Printf("Ignoring failed hook: %v\n", err)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, hook config.Hook, env []string) error {
+	if hook.Timeout != "" {
+		timeout, err := time.ParseDuration(hook.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Env = append(os.Environ(), env...)
+	// stdout is reserved for data, so hook output goes to stderr
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	killProcessGroup(cmd)
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %q", hook.Timeout, hook.Command)
+	}
+	if err != nil {
+		return fmt.Errorf("running %q: %w", hook.Command, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jacobmiller22/volume-backup/internal/config"
+)
+
+func TestRun(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	hooks := []config.Hook{
+		{Command: `echo "$VOLBACK_HOOK $VOLBACK_OPERATION" >> ` + out},
+		{Command: "exit 1", ContinueOnError: true},
+		{Command: `echo second >> ` + out},
+	}
+
+	if err := Run(t.Context(), "pre_backup", hooks, []string{"VOLBACK_OPERATION=backup"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("unexpected error reading hook output: %v", err)
+	}
+	if diff := cmp.Diff("pre_backup backup\nsecond\n", string(got)); diff != "" {
+		t.Errorf("hook output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRun_FailureAborts(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	hooks := []config.Hook{
+		{Command: "exit 3"},
+		{Command: "touch " + out},
+	}
+
+	if err := Run(t.Context(), "pre_backup", hooks, nil); err == nil {
+		t.Fatalf("expected error from failing hook, got nil")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected hooks after a failure not to run, got: %v", err)
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	hooks := []config.Hook{
+		{Command: "sleep 5", Timeout: "50ms"},
+	}
+
+	err := Run(t.Context(), "pre_backup", hooks, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got: %v", err)
+	}
+}
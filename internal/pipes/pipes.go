@@ -61,6 +61,8 @@ func NewIOPipeline(pipes []IOPipe) (*IOPipeline, error) {
 // pipeline where input is taken from the given io.reader, and output can be read from the
 // returned io.Reader
 // Processing errors get propogated to the .CloseWithError() calls.
+// Cancelling ctx closes every stage's output with ctx's error, so stages
+// blocked on reading or writing return and the returned reader fails.
 func (pl *IOPipeline) Execute(ctx context.Context, r io.Reader) io.Reader {
 	for _, pipe := range pl.pipes {
 		pr, pw := io.Pipe()
@@ -70,7 +72,12 @@ func (pl *IOPipeline) Execute(ctx context.Context, r io.Reader) io.Reader {
 				defer closer.Close()
 			}
 
-			err := p.Process(ctx, reader, writer)
+			stop := context.AfterFunc(ctx, func() {
+				writer.CloseWithError(ctx.Err())
+			})
+			defer stop()
+
+			err := p.Process(ctx, &ctxReader{ctx, reader}, writer)
 
 			defer writer.CloseWithError(err)
 			if err != nil {
@@ -83,3 +90,17 @@ func (pl *IOPipeline) Execute(ctx context.Context, r io.Reader) io.Reader {
 
 	return r
 }
+
+// ctxReader fails once ctx is done, so a stage stops consuming its input
+// after the pipeline is cancelled
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
@@ -2,6 +2,7 @@ package pipes
 
 import (
 	"context"
+	"errors"
 	"io"
 	"strings"
 	"testing"
@@ -42,3 +43,36 @@ func TestPipelineExecute(t *testing.T) {
 		t.Fatal(diff)
 	}
 }
+
+// zeroReader never runs out of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
+
+func TestPipelineExecute_Cancel(t *testing.T) {
+	pl, err := NewIOPipeline(
+		[]IOPipe{
+			NewIOPipe("1", passthroughTransformer),
+			NewIOPipe("2", passthroughTransformer),
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	output := pl.Execute(ctx, zeroReader{})
+
+	if _, err := io.ReadFull(output, make([]byte, 1024)); err != nil {
+		t.Fatalf("unexpected error before cancelling: %v", err)
+	}
+	cancel()
+
+	_, err = io.Copy(io.Discard, output)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the pipeline to fail with context.Canceled, got: %v", err)
+	}
+}
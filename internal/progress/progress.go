@@ -0,0 +1,234 @@
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Formats progress can be reported in
+const (
+	// FormatText logs a line describing progress, and is the default
+	FormatText = "text"
+	// FormatJSON writes a JSON object per line for other programs to parse
+	FormatJSON = "json"
+	// FormatQuiet reports nothing
+	FormatQuiet = "quiet"
+)
+
+// Meter tracks how far a backup or restore has got. Its counters are updated
+// by the pipes of a running pipeline and can be read at any time.
+type Meter struct {
+	start time.Time
+
+	// Read and Written count the bytes going into and coming out of the
+	// pipeline
+	Read    atomic.Int64
+	Written atomic.Int64
+
+	// total is how many bytes the source holds, or 0 until it is known
+	total   atomic.Int64
+	archive atomic.Pointer[func() (files, bytes int64)]
+}
+
+// NewMeter creates a meter for a run starting now
+func NewMeter() *Meter {
+	return &Meter{start: time.Now()}
+}
+
+// Reset starts the meter over for a new run. It must not be called while a
+// run is updating it.
+func (m *Meter) Reset() {
+	m.start = time.Now()
+	m.Read.Store(0)
+	m.Written.Store(0)
+	m.total.Store(0)
+	m.archive.Store(nil)
+}
+
+// SetTotal records how many bytes will be read from the source, once a
+// pre-walk has found out, so an ETA can be given
+func (m *Meter) SetTotal(bytes int64) {
+	m.total.Store(bytes)
+}
+
+// TrackArchive measures the source by progress, which returns how many files
+// have been archived and how many bytes have been read from them, instead of
+// by the bytes of archive read from it
+func (m *Meter) TrackArchive(progress func() (files, bytes int64)) {
+	m.archive.Store(&progress)
+}
+
+// Snapshot is how far a run had got at some point
+type Snapshot struct {
+	Elapsed      time.Duration
+	BytesRead    int64
+	BytesWritten int64
+	// Files is how many files have been archived, if the source is being
+	// archived
+	Files int64
+	// Done is how many bytes of the source have been read, and Total how
+	// many it holds, or 0 if that isn't known
+	Done  int64
+	Total int64
+	// BytesPerSecond is how fast the source is being read
+	BytesPerSecond float64
+	// ETA estimates how much longer reading the source will take, or is 0
+	// if it can't be estimated
+	ETA time.Duration
+}
+
+// Snapshot reads the meter's counters
+func (m *Meter) Snapshot() Snapshot {
+	return m.snapshotAt(time.Now())
+}
+
+func (m *Meter) snapshotAt(now time.Time) Snapshot {
+	s := Snapshot{
+		Elapsed:      now.Sub(m.start),
+		BytesRead:    m.Read.Load(),
+		BytesWritten: m.Written.Load(),
+		Total:        m.total.Load(),
+	}
+	s.Done = s.BytesRead
+	if archive := m.archive.Load(); archive != nil {
+		s.Files, s.Done = (*archive)()
+	}
+	if s.Elapsed > 0 {
+		s.BytesPerSecond = float64(s.Done) / s.Elapsed.Seconds()
+	}
+	if s.Total > 0 && s.BytesPerSecond > 0 && s.Done <= s.Total {
+		remaining := float64(s.Total-s.Done) / s.BytesPerSecond
+		s.ETA = time.Duration(remaining * float64(time.Second)).Round(time.Second)
+	}
+	return s
+}
+
+// Report writes how far meter has got to w every interval, in format, until
+// the returned stop func is called. Stop writes a last line with the totals.
+// operation names the run, e.g. "backup".
+func Report(ctx context.Context, w io.Writer, format string, interval time.Duration, operation string, meter *Meter) (stop func()) {
+	if format == FormatQuiet {
+		return func() {}
+	}
+	r := &reporter{w: w, format: format, operation: operation, logger: log.New(w, "", log.LstdFlags)}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report(meter.Snapshot(), false)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			wg.Wait()
+			r.report(meter.Snapshot(), true)
+		})
+	}
+}
+
+type reporter struct {
+	w         io.Writer
+	format    string
+	operation string
+	logger    *log.Logger
+}
+
+// jsonLine is what FormatJSON writes for every snapshot
+type jsonLine struct {
+	Operation      string  `json:"operation"`
+	Final          bool    `json:"final"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	BytesRead      int64   `json:"bytes_read"`
+	BytesWritten   int64   `json:"bytes_written"`
+	Files          int64   `json:"files"`
+	DoneBytes      int64   `json:"done_bytes"`
+	TotalBytes     int64   `json:"total_bytes,omitempty"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	ETASeconds     float64 `json:"eta_seconds,omitempty"`
+}
+
+func (r *reporter) report(s Snapshot, final bool) {
+	if r.format == FormatJSON {
+		// A failed write to stderr isn't worth failing the run over
+		_ = json.NewEncoder(r.w).Encode(jsonLine{
+			Operation:      r.operation,
+			Final:          final,
+			ElapsedSeconds: s.Elapsed.Seconds(),
+			BytesRead:      s.BytesRead,
+			BytesWritten:   s.BytesWritten,
+			Files:          s.Files,
+			DoneBytes:      s.Done,
+			TotalBytes:     s.Total,
+			BytesPerSecond: s.BytesPerSecond,
+			ETASeconds:     s.ETA.Seconds(),
+		})
+		return
+	}
+
+	if final {
+		r.logger.Printf("%s totals after %s: %s\n", capitalize(r.operation), s.Elapsed.Round(time.Second), describe(s, false))
+		return
+	}
+	r.logger.Printf("%s progress: %s\n", capitalize(r.operation), describe(s, true))
+}
+
+// describe summarises s for a person, including how much is left if
+// remaining is set
+func describe(s Snapshot, remaining bool) string {
+	parts := []string{
+		FormatBytes(s.BytesRead) + " read",
+		FormatBytes(s.BytesWritten) + " written",
+	}
+	if s.Files > 0 {
+		parts = append(parts, fmt.Sprintf("%d files", s.Files))
+	}
+	parts = append(parts, FormatBytes(int64(s.BytesPerSecond))+"/s")
+	if remaining && s.Total > 0 {
+		parts = append(parts, fmt.Sprintf("%s of %s (%d%%)", FormatBytes(s.Done), FormatBytes(s.Total), min(s.Done, s.Total)*100/s.Total))
+		if s.ETA > 0 {
+			parts = append(parts, "ETA "+s.ETA.String())
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatBytes describes n bytes in binary units, e.g. "1.5 GiB"
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,172 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMeter_Snapshot(t *testing.T) {
+	testCases := []struct {
+		name    string
+		read    int64
+		total   int64
+		archive func() (int64, int64)
+		want    Snapshot
+	}{
+		{
+			name: "unknown total",
+			read: 1000,
+			want: Snapshot{Elapsed: 10 * time.Second, BytesRead: 1000, Done: 1000, BytesPerSecond: 100},
+		},
+		{
+			name:  "known total",
+			read:  1000,
+			total: 4000,
+			want:  Snapshot{Elapsed: 10 * time.Second, BytesRead: 1000, Done: 1000, Total: 4000, BytesPerSecond: 100, ETA: 30 * time.Second},
+		},
+		{
+			name:    "archive",
+			read:    300,
+			total:   2000,
+			archive: func() (int64, int64) { return 7, 500 },
+			want:    Snapshot{Elapsed: 10 * time.Second, BytesRead: 300, Files: 7, Done: 500, Total: 2000, BytesPerSecond: 50, ETA: 30 * time.Second},
+		},
+		{
+			name:  "source grew past its total",
+			read:  5000,
+			total: 4000,
+			want:  Snapshot{Elapsed: 10 * time.Second, BytesRead: 5000, Done: 5000, Total: 4000, BytesPerSecond: 500},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMeter()
+			m.Read.Store(tc.read)
+			m.SetTotal(tc.total)
+			if tc.archive != nil {
+				m.TrackArchive(tc.archive)
+			}
+
+			got := m.snapshotAt(m.start.Add(10 * time.Second))
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("snapshot mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMeter_Reset(t *testing.T) {
+	m := NewMeter()
+	m.Read.Store(10)
+	m.Written.Store(20)
+	m.SetTotal(30)
+	m.TrackArchive(func() (int64, int64) { return 1, 2 })
+
+	m.Reset()
+	s := m.Snapshot()
+	if s.BytesRead != 0 || s.BytesWritten != 0 || s.Total != 0 || s.Files != 0 || s.Done != 0 {
+		t.Errorf("expected every counter to be reset, got %+v", s)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	s := Snapshot{
+		BytesRead:      3 << 30,
+		BytesWritten:   1536 << 20,
+		Files:          42,
+		Done:           1 << 30,
+		Total:          4 << 30,
+		BytesPerSecond: 10 << 20,
+		ETA:            5 * time.Minute,
+	}
+	want := "3.0 GiB read, 1.5 GiB written, 42 files, 10.0 MiB/s, 1.0 GiB of 4.0 GiB (25%), ETA 5m0s"
+	if got := describe(s, true); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	want = "3.0 GiB read, 1.5 GiB written, 42 files, 10.0 MiB/s"
+	if got := describe(s, false); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		n    int64
+		want string
+	}{
+		{n: 0, want: "0 B"},
+		{n: 1023, want: "1023 B"},
+		{n: 1024, want: "1.0 KiB"},
+		{n: 1536 << 10, want: "1.5 MiB"},
+		{n: 5 << 40, want: "5.0 TiB"},
+	}
+
+	for _, tc := range testCases {
+		if got := FormatBytes(tc.n); got != tc.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		m := NewMeter()
+		m.Read.Store(100)
+		m.Written.Store(120)
+		m.SetTotal(400)
+
+		var out bytes.Buffer
+		stop := Report(t.Context(), &out, FormatJSON, 10*time.Millisecond, "backup", m)
+		time.Sleep(50 * time.Millisecond)
+		stop()
+		// Stopping again doesn't report twice
+		stop()
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) < 2 {
+			t.Fatalf("expected periodic reports and a final one, got: %q", out.String())
+		}
+		for i, line := range lines {
+			var got jsonLine
+			if err := json.Unmarshal([]byte(line), &got); err != nil {
+				t.Fatalf("line %d isn't json: %v", i, err)
+			}
+			if got.Final != (i == len(lines)-1) {
+				t.Errorf("line %d: expected only the last line to be final, got final=%v", i, got.Final)
+			}
+			if got.Operation != "backup" || got.BytesRead != 100 || got.BytesWritten != 120 || got.DoneBytes != 100 || got.TotalBytes != 400 {
+				t.Errorf("line %d: unexpected report %+v", i, got)
+			}
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		m := NewMeter()
+		m.Read.Store(2048)
+
+		var out bytes.Buffer
+		stop := Report(t.Context(), &out, FormatText, time.Hour, "restore", m)
+		stop()
+
+		if got := out.String(); !strings.Contains(got, "Restore totals after") || !strings.Contains(got, "2.0 KiB read") {
+			t.Errorf("unexpected final report: %q", got)
+		}
+	})
+
+	t.Run("quiet", func(t *testing.T) {
+		var out bytes.Buffer
+		stop := Report(t.Context(), &out, FormatQuiet, time.Millisecond, "backup", NewMeter())
+		time.Sleep(10 * time.Millisecond)
+		stop()
+
+		if out.Len() != 0 {
+			t.Errorf("expected nothing to be reported, got: %q", out.String())
+		}
+	})
+}
@@ -0,0 +1,337 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// ModeReflink copies the source tree, cloning file contents with FICLONE.
+	// It fails if the filesystem can't clone files, unless AllowCopy is set.
+	ModeReflink = "reflink"
+	// ModeCommand runs a user defined command to populate the snapshot, e.g.
+	// creating and mounting an LVM or btrfs snapshot
+	ModeCommand = "command"
+)
+
+// Options configures how a snapshot is taken
+type Options struct {
+	Mode string
+
+	// Command is run with `sh -c` in ModeCommand. It receives the source in
+	// $VOLBACK_SOURCE_PATH and must make the snapshot available at
+	// $VOLBACK_SNAPSHOT_PATH, an empty directory created beforehand.
+	Command string
+	// CleanupCommand is run with the same environment once the backup is
+	// finished, whether or not it succeeded
+	CleanupCommand string
+
+	// Dir is the parent directory of the snapshot. Defaults to the parent of
+	// the source so that reflinks stay on the same filesystem.
+	Dir string
+
+	// OneFileSystem keeps ModeReflink from descending into directories on
+	// other filesystems than the source, i.e. mount points
+	OneFileSystem bool
+	// SkipUnreadable leaves files that can't be read out of a ModeReflink
+	// snapshot, recording a warning, instead of failing it
+	SkipUnreadable bool
+	// AllowCopy lets ModeReflink copy file contents when they can't be
+	// cloned. A full copy of a large source can fill the disk it is made on,
+	// so it has to be asked for.
+	AllowCopy bool
+}
+
+// Snapshot is a point in time copy of a source path
+type Snapshot struct {
+	// Path to archive in place of the source
+	Path string
+
+	// Warnings are files left out of the snapshot because they couldn't be
+	// read
+	Warnings []error
+
+	root    string
+	env     []string
+	opts    Options
+	cleaned bool
+}
+
+// Create takes a snapshot of source. The returned Snapshot must be cleaned up
+// with Cleanup, even if the backup fails.
+func Create(ctx context.Context, source string, opts Options) (*Snapshot, error) {
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := opts.Dir
+	if dir == "" {
+		dir = filepath.Dir(source)
+	}
+	root, err := os.MkdirTemp(dir, ".volback-snapshot-")
+	if err != nil {
+		return nil, fmt.Errorf("creating snapshot directory: %w", err)
+	}
+
+	s := &Snapshot{
+		root: root,
+		opts: opts,
+		env: append(os.Environ(),
+			"VOLBACK_SOURCE_PATH="+source,
+			"VOLBACK_SNAPSHOT_PATH="+root,
+		),
+	}
+
+	switch opts.Mode {
+	case ModeReflink:
+		// Keep the base name so the archive looks the same as it would
+		// without a snapshot
+		s.Path = filepath.Join(root, filepath.Base(source))
+		c := &copier{opts: opts}
+		err = c.copyTree(source, s.Path)
+		s.Warnings = c.warnings
+	case ModeCommand:
+		s.Path = root
+		err = s.run(ctx, opts.Command)
+	default:
+		err = fmt.Errorf("unknown snapshot mode %q", opts.Mode)
+	}
+
+	if err != nil {
+		// Clean up even if ctx was cancelled, or a mounted snapshot is left behind
+		return nil, errors.Join(err, s.Cleanup(context.WithoutCancel(ctx)))
+	}
+	return s, nil
+}
+
+// Cleanup removes the snapshot. It is safe to call more than once.
+func (s *Snapshot) Cleanup(ctx context.Context) error {
+	if s.cleaned {
+		return nil
+	}
+	s.cleaned = true
+
+	switch s.opts.Mode {
+	case ModeCommand:
+		if s.opts.CleanupCommand != "" {
+			if err := s.run(ctx, s.opts.CleanupCommand); err != nil {
+				return fmt.Errorf("snapshot cleanup command: %w", err)
+			}
+		}
+		// Only ever remove the directory itself, anything left inside could
+		// still be mounted
+		if err := os.Remove(s.root); err != nil {
+			return fmt.Errorf("removing snapshot directory: %w", err)
+		}
+		return nil
+	default:
+		// Read-only directories copied from the source can't be emptied
+		// without root, so make them writable first
+		filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				os.Chmod(path, 0700)
+			}
+			return nil
+		})
+		return os.RemoveAll(s.root)
+	}
+}
+
+func (s *Snapshot) run(ctx context.Context, command string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = s.env
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %q: %w", command, err)
+	}
+	return nil
+}
+
+// readError is an error reading from the source, as opposed to an error
+// writing the snapshot
+type readError struct {
+	path string
+	err  error
+}
+
+func (e *readError) Error() string {
+	return fmt.Sprintf("%s: %v", e.path, e.err)
+}
+
+func (e *readError) Unwrap() error {
+	return e.err
+}
+
+// copier copies a source tree into a ModeReflink snapshot
+type copier struct {
+	opts     Options
+	warnings []error
+
+	warnNoReflink sync.Once
+}
+
+// skippable reports whether err is a read error that the options allow to be
+// recorded as a warning instead of failing the snapshot
+func (c *copier) skippable(err error) bool {
+	var re *readError
+	if !c.opts.SkipUnreadable || !errors.As(err, &re) {
+		return false
+	}
+	log.Printf("Leaving unreadable file out of the snapshot: %v\n", err)
+	c.warnings = append(c.warnings, err)
+	return true
+}
+
+// copyTree copies src to dst, cloning file contents and preserving
+// permissions and modification times
+func (c *copier) copyTree(src string, dst string) error {
+	type dirAttrs struct {
+		path  string
+		perm  fs.FileMode
+		mtime time.Time
+	}
+	// Directory permissions and times have to be set after their contents
+	// are written, or a read-only directory couldn't be filled
+	var dirs []dirAttrs
+
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return fmt.Errorf("copying %s to snapshot: %w", src, err)
+	}
+	srcDev, hasDev := deviceID(srcInfo)
+
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The entry itself, or the listing of a directory, is unreadable
+			if c.skippable(&readError{path, err}) {
+				if d != nil && d.IsDir() && path != src {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			if c.skippable(&readError{path, err}) {
+				return nil
+			}
+			return err
+		}
+		mode := info.Mode()
+
+		switch {
+		case mode.IsDir():
+			if err := os.Mkdir(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirAttrs{target, mode.Perm(), info.ModTime()})
+			if c.opts.OneFileSystem && hasDev {
+				if dev, ok := deviceID(info); ok && dev != srcDev {
+					// Keep the mount point itself, but none of its contents
+					return fs.SkipDir
+				}
+			}
+			return nil
+		case mode.IsRegular():
+			if err := c.copyFile(path, target, mode.Perm()); err != nil {
+				if c.skippable(err) {
+					// Don't leave a partial copy behind to be archived
+					os.Remove(target)
+					return nil
+				}
+				return err
+			}
+		case mode&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				if c.skippable(&readError{path, err}) {
+					return nil
+				}
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			if err := copySpecial(info, target); err != nil {
+				return fmt.Errorf("recreating %s: %w", path, err)
+			}
+		}
+		return os.Chtimes(target, time.Time{}, info.ModTime())
+	})
+	if err != nil {
+		return fmt.Errorf("copying %s to snapshot: %w", src, err)
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].perm); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dirs[i].path, time.Time{}, dirs[i].mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile clones src to dst, or copies its contents if it can't be cloned
+// and that is allowed. Failures to read src are returned as a *readError.
+func (c *copier) copyFile(src string, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return &readError{src, err}
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	err = reflink(out, in)
+	if err == nil {
+		return out.Close()
+	}
+	if !c.opts.AllowCopy {
+		return fmt.Errorf("cloning %s: %w (set snapshot_allow_copy to copy file contents instead)", src, err)
+	}
+	c.warnNoReflink.Do(func() {
+		log.Printf("Reflinks unavailable, falling back to copying file contents: %v\n", err)
+	})
+
+	if _, err := io.Copy(out, &sourceReader{src, in}); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// sourceReader returns every error reading from r as a *readError
+type sourceReader struct {
+	path string
+	r    io.Reader
+}
+
+func (sr *sourceReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &readError{sr.path, err}
+	}
+	return n, err
+}
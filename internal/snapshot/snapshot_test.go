@@ -0,0 +1,226 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCreate_Reflink(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "volume")
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatalf("unexpected error creating source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a", "a.txt"), []byte("original"), 0644); err != nil {
+		t.Fatalf("unexpected error writing source: %v", err)
+	}
+
+	snap, err := Create(t.Context(), src, Options{Mode: ModeReflink, AllowCopy: true})
+	if err != nil {
+		t.Fatalf("unexpected error creating snapshot: %v", err)
+	}
+
+	if diff := cmp.Diff("volume", filepath.Base(snap.Path)); diff != "" {
+		t.Errorf("snapshot should keep the source's base name (-want +got):\n%s", diff)
+	}
+
+	// Writes to the source after the snapshot must not be visible in it
+	if err := os.WriteFile(filepath.Join(src, "a", "a.txt"), []byte("modified"), 0644); err != nil {
+		t.Fatalf("unexpected error modifying source: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(snap.Path, "a", "a.txt"))
+	if err != nil {
+		t.Fatalf("unexpected error reading snapshot: %v", err)
+	}
+	if diff := cmp.Diff("original", string(got)); diff != "" {
+		t.Errorf("snapshot contents mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := snap.Cleanup(t.Context()); err != nil {
+		t.Fatalf("unexpected error cleaning up: %v", err)
+	}
+	if _, err := os.Stat(snap.Path); !os.IsNotExist(err) {
+		t.Errorf("expected snapshot to be removed, got: %v", err)
+	}
+}
+
+func TestCreate_ReflinkReadOnlyDir(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "volume")
+	if err := os.MkdirAll(filepath.Join(src, "ro"), 0755); err != nil {
+		t.Fatalf("unexpected error creating source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "ro", "a.txt"), []byte("original"), 0644); err != nil {
+		t.Fatalf("unexpected error writing source: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(src, "ro"), 0555); err != nil {
+		t.Fatalf("unexpected error making source read-only: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(filepath.Join(src, "ro"), 0755) })
+
+	snap, err := Create(t.Context(), src, Options{Mode: ModeReflink, AllowCopy: true})
+	if err != nil {
+		t.Fatalf("unexpected error creating snapshot: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(snap.Path, "ro", "a.txt")); err != nil {
+		t.Errorf("expected the read-only directory to be filled: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(snap.Path, "ro"))
+	if err != nil {
+		t.Fatalf("unexpected error stating snapshot directory: %v", err)
+	}
+	if diff := cmp.Diff(fs.FileMode(0555), info.Mode().Perm()); diff != "" {
+		t.Errorf("directory permissions mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := snap.Cleanup(t.Context()); err != nil {
+		t.Fatalf("unexpected error cleaning up: %v", err)
+	}
+	if _, err := os.Stat(snap.Path); !os.IsNotExist(err) {
+		t.Errorf("expected snapshot to be removed, got: %v", err)
+	}
+}
+
+func TestCreate_Command(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("unexpected error writing source: %v", err)
+	}
+	marker := filepath.Join(t.TempDir(), "cleaned")
+
+	snap, err := Create(t.Context(), src, Options{
+		Mode:           ModeCommand,
+		Command:        `cp "$VOLBACK_SOURCE_PATH/a.txt" "$VOLBACK_SNAPSHOT_PATH/a.txt"`,
+		CleanupCommand: `rm "$VOLBACK_SNAPSHOT_PATH/a.txt" && touch ` + marker,
+		Dir:            t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating snapshot: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(snap.Path, "a.txt")); err != nil {
+		t.Errorf("expected command to populate snapshot: %v", err)
+	}
+
+	if err := snap.Cleanup(t.Context()); err != nil {
+		t.Fatalf("unexpected error cleaning up: %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected cleanup command to run: %v", err)
+	}
+	if _, err := os.Stat(snap.Path); !os.IsNotExist(err) {
+		t.Errorf("expected snapshot directory to be removed, got: %v", err)
+	}
+}
+
+func TestCreate_CommandFailureCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "cleaned")
+
+	_, err := Create(t.Context(), t.TempDir(), Options{
+		Mode:           ModeCommand,
+		Command:        "exit 1",
+		CleanupCommand: "touch " + marker,
+		Dir:            dir,
+	})
+	if err == nil {
+		t.Fatalf("expected error from failing snapshot command, got nil")
+	}
+
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected cleanup command to run after failure: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error reading snapshot parent: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected snapshot directory to be removed, got: %v", entries)
+	}
+}
+
+func TestCreate_CancelledCleansUp(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "cleaned")
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	_, err := Create(ctx, t.TempDir(), Options{
+		Mode:           ModeCommand,
+		Command:        "true",
+		CleanupCommand: "touch " + marker,
+		Dir:            t.TempDir(),
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the snapshot command to be cancelled, got: %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected cleanup command to run after cancellation: %v", err)
+	}
+}
+
+func TestCreate_ReflinkUnsupported(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "volume")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatalf("unexpected error creating source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("original"), 0644); err != nil {
+		t.Fatalf("unexpected error writing source: %v", err)
+	}
+
+	snap, err := Create(t.Context(), src, Options{Mode: ModeReflink})
+	if err == nil {
+		snap.Cleanup(t.Context())
+		t.Skip("the temporary directory supports reflinks")
+	}
+	if !strings.Contains(err.Error(), "snapshot_allow_copy") {
+		t.Errorf("expected the error to suggest allowing a copy, got: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(src))
+	if err != nil {
+		t.Fatalf("unexpected error reading snapshot parent: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected the failed snapshot to be removed, got: %v", entries)
+	}
+}
+
+func TestCopier_SkipUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "vanished.txt")
+
+	testCases := []struct {
+		name         string
+		skip         bool
+		wantWarnings int
+	}{
+		{name: "fail", skip: false, wantWarnings: 0},
+		{name: "skip", skip: true, wantWarnings: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &copier{opts: Options{SkipUnreadable: tc.skip, AllowCopy: true}}
+
+			// A file that disappears between the walk and the copy is the
+			// portable way to provoke a read error, since permissions don't
+			// stop root
+			err := c.copyFile(missing, filepath.Join(dir, tc.name), 0644)
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expected error copying vanished file, got: %v", err)
+			}
+			if got := c.skippable(err); got != tc.skip {
+				t.Errorf("skippable() = %v, want %v", got, tc.skip)
+			}
+			if got := len(c.warnings); got != tc.wantWarnings {
+				t.Errorf("got %d warnings, want %d", got, tc.wantWarnings)
+			}
+		})
+	}
+}
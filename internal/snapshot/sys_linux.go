@@ -0,0 +1,34 @@
+//go:build linux
+
+package snapshot
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink shares the extents of src with dst using the FICLONE ioctl
+func reflink(dst *os.File, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
+
+// copySpecial recreates a FIFO, socket or device node described by info
+func copySpecial(info fs.FileInfo, dst string) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fs.ErrInvalid
+	}
+	return unix.Mknod(dst, st.Mode, int(st.Rdev))
+}
+
+// deviceID returns the ID of the device containing the file described by info
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
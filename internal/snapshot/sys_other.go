@@ -0,0 +1,25 @@
+//go:build !linux
+
+package snapshot
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+var errUnsupported = errors.New("unsupported platform")
+
+func reflink(dst *os.File, src *os.File) error {
+	return errUnsupported
+}
+
+func copySpecial(info fs.FileInfo, dst string) error {
+	return errUnsupported
+}
+
+// deviceID is unsupported on this platform, so every file is assumed to be on
+// the same filesystem
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,135 @@
+package volback
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/jacobmiller22/volume-backup/backend"
+)
+
+func init() {
+	backend.Register("azblob", backend.Backend{
+		NewPuller: func(_ context.Context, loc *backend.Location) (Puller, error) {
+			return newAzblobPushPullerFromLocation(loc)
+		},
+		NewPusher: func(_ context.Context, loc *backend.Location) (Pusher, error) {
+			return newAzblobPushPullerFromLocation(loc)
+		},
+		Options: &AzblobOptions{},
+		Validate: func(loc *backend.Location) error {
+			opts, err := decodeOptions[AzblobOptions](loc)
+			if err != nil {
+				return err
+			}
+			if opts.Azblob_Account == "" || opts.Azblob_Container == "" {
+				return fmt.Errorf("azblob_account and azblob_container are required")
+			}
+			if (opts.Azblob_AccountKey == "") == (opts.Azblob_SasToken == "") {
+				return fmt.Errorf("exactly one of azblob_account_key or azblob_sas_token is required")
+			}
+			return nil
+		},
+	})
+}
+
+// AzblobOptions configure an azblob location
+type AzblobOptions struct {
+	Azblob_Account    string `json:"azblob_account" usage:"Storage account name"`
+	Azblob_AccountKey string `json:"azblob_account_key" usage:"Storage account key for shared key auth"`
+	Azblob_SasToken   string `json:"azblob_sas_token" usage:"SAS token to authenticate with instead of a shared key"`
+	Azblob_Container  string `json:"azblob_container" usage:"Name of the blob container"`
+	// Defaults to https://<account>.blob.core.windows.net
+	Azblob_Endpoint        string `json:"azblob_endpoint" usage:"Overrides the blob service url, e.g. for Azurite"`
+	Azblob_CreateContainer bool   `json:"azblob_create_container" usage:"Create the container if it doesn't exist"`
+
+	Azblob_BlockSize   int `json:"azblob_block_size" usage:"Size in bytes of each staged block"`
+	Azblob_Concurrency int `json:"azblob_concurrency" usage:"Number of blocks to upload concurrently"`
+}
+
+func newAzblobPushPullerFromLocation(loc *backend.Location) (*AzblobPushPuller, error) {
+	opts, err := decodeOptions[AzblobOptions](loc)
+	if err != nil {
+		return nil, err
+	}
+	return newAzblobPushPuller(opts)
+}
+
+func newAzblobContainerClient(opts *AzblobOptions) (*container.Client, error) {
+	endpoint := opts.Azblob_Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", opts.Azblob_Account)
+	}
+	containerURL, err := url.JoinPath(endpoint, opts.Azblob_Container)
+	if err != nil {
+		return nil, fmt.Errorf("invalid azblob endpoint: %w", err)
+	}
+
+	if opts.Azblob_SasToken != "" {
+		return container.NewClientWithNoCredential(containerURL+"?"+strings.TrimPrefix(opts.Azblob_SasToken, "?"), nil)
+	}
+
+	cred, err := azblob.NewSharedKeyCredential(opts.Azblob_Account, opts.Azblob_AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid azblob shared key: %w", err)
+	}
+	return container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+}
+
+func newAzblobPushPuller(opts *AzblobOptions) (*AzblobPushPuller, error) {
+	client, err := newAzblobContainerClient(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AzblobPushPuller{
+		client:          client,
+		createContainer: opts.Azblob_CreateContainer,
+		blockSize:       int64(opts.Azblob_BlockSize),
+		concurrency:     opts.Azblob_Concurrency,
+	}, nil
+}
+
+// AzblobPushPuller stores backups as block blobs in an Azure Storage container
+type AzblobPushPuller struct {
+	client          *container.Client
+	createContainer bool
+
+	blockSize   int64
+	concurrency int
+}
+
+func (p *AzblobPushPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	resp, err := p.client.NewBlobClient(path).DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob %s: %w", path, err)
+	}
+
+	// The retry reader resumes from the last received byte if the connection drops
+	return resp.NewRetryReader(ctx, &blob.RetryReaderOptions{}), nil
+}
+
+// Push stages r as blocks of the blob at path, then commits the block list
+func (p *AzblobPushPuller) Push(ctx context.Context, r io.Reader, path string) error {
+	if p.createContainer {
+		if _, err := p.client.Create(ctx, nil); err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+			return fmt.Errorf("failed to create container: %w", err)
+		}
+	}
+
+	_, err := p.client.NewBlockBlobClient(path).UploadStream(ctx, r, &blockblob.UploadStreamOptions{
+		BlockSize:   p.blockSize,
+		Concurrency: p.concurrency,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload blob %s: %w", path, err)
+	}
+	return nil
+}
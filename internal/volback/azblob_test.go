@@ -0,0 +1,148 @@
+package volback
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBlobService implements just enough of the Blob service REST API to stage
+// and commit block blobs and download them again
+type fakeBlobService struct {
+	mu     sync.Mutex
+	blocks map[string][]byte
+	blobs  map[string][]byte
+	staged int
+
+	// sig is the signature required in the query string, if any
+	sig string
+}
+
+func (f *fakeBlobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	if f.sig != "" && q.Get("sig") != f.sig {
+		w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("ETag", `"0x1"`)
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+
+	switch {
+	case r.Method == http.MethodPut && q.Get("restype") == "container":
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.blocks[q.Get("blockid")] = body
+		f.staged++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var blob bytes.Buffer
+		for _, id := range list.Latest {
+			blob.Write(f.blocks[id])
+		}
+		f.blobs[r.URL.Path] = blob.Bytes()
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet:
+		blob, ok := f.blobs[r.URL.Path]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(blob)-1, len(blob)))
+		w.Write(blob)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAzblobPushPuller(t *testing.T) {
+	testCases := []struct {
+		name string
+		loc  AzblobOptions
+		sig  string
+	}{
+		{
+			name: "shared key",
+			loc: AzblobOptions{
+				Azblob_AccountKey: base64.StdEncoding.EncodeToString([]byte("not a real account key")),
+			},
+		},
+		{
+			name: "sas token",
+			loc: AzblobOptions{
+				Azblob_SasToken: "?sv=2023-11-03&sp=rcw&sig=signature",
+			},
+			sig: "signature",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeBlobService{blocks: map[string][]byte{}, blobs: map[string][]byte{}, sig: tc.sig}
+			srv := httptest.NewServer(fake)
+			t.Cleanup(srv.Close)
+
+			loc := tc.loc
+			loc.Azblob_Account = "devstoreaccount1"
+			loc.Azblob_Container = "backups"
+			loc.Azblob_Endpoint = srv.URL + "/devstoreaccount1"
+			loc.Azblob_CreateContainer = true
+			loc.Azblob_BlockSize = 1024 * 1024
+			loc.Azblob_Concurrency = 2
+
+			p, err := newAzblobPushPuller(&loc)
+			if err != nil {
+				t.Fatalf("unexpected error creating azblob push puller: %v", err)
+			}
+
+			// Large enough to be staged as several blocks
+			given := bytes.Repeat([]byte("0123456789abcdef"), 160*1024)
+			if err := p.Push(t.Context(), bytes.NewReader(given), "nested/backup.ct"); err != nil {
+				t.Fatalf("unexpected error pushing: %v", err)
+			}
+			if fake.staged != 3 {
+				t.Errorf("got %d staged blocks, want 3", fake.staged)
+			}
+
+			r, err := p.Pull(t.Context(), "nested/backup.ct")
+			if err != nil {
+				t.Fatalf("unexpected error pulling: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error reading pulled blob: %v", err)
+			}
+			r.(io.Closer).Close()
+
+			if !bytes.Equal(given, got) {
+				t.Errorf("pulled contents mismatch: got %d bytes, want %d", len(got), len(given))
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package volback
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jacobmiller22/volume-backup/backend"
+)
+
+func init() {
+	backend.Register("exec", backend.Backend{
+		NewPuller:  func(_ context.Context, loc *backend.Location) (Puller, error) { return newExecPushPuller(loc) },
+		NewPusher:  func(_ context.Context, loc *backend.Location) (Pusher, error) { return newExecPushPuller(loc) },
+		Options:    &ExecOptions{},
+		AnyOptions: true,
+		Validate: func(loc *backend.Location) error {
+			if loc.Options[execPluginOption] == "" {
+				return fmt.Errorf("exec_plugin is required")
+			}
+			return nil
+		},
+	})
+}
+
+// execPluginOption is the option naming the plugin, every other option is
+// passed on to it
+const execPluginOption = "exec_plugin"
+
+// ExecOptions configure an exec location
+type ExecOptions struct {
+	// Exec_Plugin is either the name of a volback-backend-<name> executable
+	// on PATH, or a path to the plugin
+	Exec_Plugin string `json:"exec_plugin" usage:"Plugin to run, either foo for volback-backend-foo on PATH or a path to the executable"`
+}
+
+// ExecPluginPrefix is prepended to plugin names to find their executable on
+// PATH
+const ExecPluginPrefix = "volback-backend-"
+
+// execNotFoundCode is the exit code a plugin uses to report that there is no
+// object at a path
+const execNotFoundCode = 2
+
+// ExecPushPuller runs a plugin executable for every operation. The operation
+// and its path are passed as arguments:
+//
+//	get <path>       write the object to stdout
+//	put <path>       store the object read from stdin
+//	list <prefix>    write the path of every object below prefix to stdout, one per line
+//	delete <path>    remove the object
+//
+// A plugin exits 0 on success, 2 if there is no object at path, and with any
+// other status on failure, writing the reason to stderr. The location's
+// other options are passed as VOLBACK_OPTION_<KEY> environment variables.
+type ExecPushPuller struct {
+	plugin string
+	env    []string
+}
+
+func newExecPushPuller(loc *backend.Location) (*ExecPushPuller, error) {
+	plugin := loc.Options[execPluginOption]
+	if !strings.ContainsRune(plugin, os.PathSeparator) {
+		path, err := exec.LookPath(ExecPluginPrefix + plugin)
+		if err != nil {
+			return nil, fmt.Errorf("finding plugin %s: %w", plugin, err)
+		}
+		plugin = path
+	}
+
+	env := make([]string, 0, len(loc.Options))
+	for key, value := range loc.Options {
+		if key == execPluginOption {
+			continue
+		}
+		key = strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		env = append(env, "VOLBACK_OPTION_"+key+"="+value)
+	}
+
+	return &ExecPushPuller{plugin: plugin, env: env}, nil
+}
+
+// command runs the plugin for op on path, killing it if ctx is cancelled
+func (p *ExecPushPuller) command(ctx context.Context, op string, path string) (*exec.Cmd, *bytes.Buffer) {
+	cmd := exec.CommandContext(ctx, p.plugin, op, path)
+	cmd.Env = append(os.Environ(), p.env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	return cmd, &stderr
+}
+
+// pluginError describes a failed plugin run with what it wrote to stderr
+func pluginError(op string, path string, err error, stderr *bytes.Buffer) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == execNotFoundCode {
+		err = fs.ErrNotExist
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("plugin %s %s: %w: %s", op, path, err, msg)
+	}
+	return fmt.Errorf("plugin %s %s: %w", op, path, err)
+}
+
+// Pull streams the object from the plugin's stdout. The plugin's exit status
+// is only known once the returned reader is drained, so a failure is returned
+// from Read in place of io.EOF.
+func (p *ExecPushPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	cmd, stderr := p.command(ctx, "get", path)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, pluginError("get", path, err, stderr)
+	}
+	return &execReader{cmd: cmd, stdout: stdout, stderr: stderr, path: path}, nil
+}
+
+// Push streams r to the plugin's stdin. A plugin that exits 0 before all of
+// r could be written to it has stored a truncated object, so the push fails.
+func (p *ExecPushPuller) Push(ctx context.Context, r io.Reader, path string) error {
+	cmd, stderr := p.command(ctx, "put", path)
+	stdin := &stdinReader{r: r}
+	cmd.Stdin = stdin
+	if err := cmd.Run(); err != nil {
+		return pluginError("put", path, err, stderr)
+	}
+	if !stdin.eof {
+		return fmt.Errorf("plugin put %s: exited after reading only %d bytes of the object", path, stdin.n)
+	}
+	return nil
+}
+
+// stdinReader counts the bytes copied to a plugin's stdin and records whether
+// all of them were
+type stdinReader struct {
+	r   io.Reader
+	n   int64
+	eof bool
+}
+
+func (s *stdinReader) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	s.n += int64(n)
+	if err == io.EOF {
+		s.eof = true
+	}
+	return n, err
+}
+
+// List returns the paths the plugin lists below prefix
+func (p *ExecPushPuller) List(ctx context.Context, prefix string) ([]string, error) {
+	cmd, stderr := p.command(ctx, "list", prefix)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, pluginError("list", prefix, err, stderr)
+	}
+
+	var paths []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+func (p *ExecPushPuller) Delete(ctx context.Context, path string) error {
+	cmd, stderr := p.command(ctx, "delete", path)
+	if err := cmd.Run(); err != nil {
+		return pluginError("delete", path, err, stderr)
+	}
+	return nil
+}
+
+// execReader reads a plugin's stdout and reaps the plugin once it is drained
+type execReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+	path   string
+	done   bool
+	err    error
+}
+
+func (r *execReader) Read(b []byte) (int, error) {
+	if r.done {
+		return 0, r.result()
+	}
+	n, err := r.stdout.Read(b)
+	if err == io.EOF {
+		r.wait()
+		return n, r.result()
+	}
+	return n, err
+}
+
+// Close kills the plugin if it hasn't finished writing the object
+func (r *execReader) Close() error {
+	if r.done {
+		return nil
+	}
+	r.cmd.Process.Kill()
+	r.wait()
+	return nil
+}
+
+func (r *execReader) wait() {
+	r.done = true
+	if err := r.cmd.Wait(); err != nil {
+		r.err = pluginError("get", r.path, err, r.stderr)
+	}
+}
+
+func (r *execReader) result() error {
+	if r.err != nil {
+		return r.err
+	}
+	return io.EOF
+}
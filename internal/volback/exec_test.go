@@ -0,0 +1,157 @@
+package volback
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jacobmiller22/volume-backup/backend"
+	"github.com/jacobmiller22/volume-backup/internal/progress"
+)
+
+// newTestExecPushPuller runs the dir plugin in testdata, found on PATH
+func newTestExecPushPuller(t *testing.T) (*ExecPushPuller, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the test plugin is a shell script")
+	}
+
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", testdata+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	root := t.TempDir()
+	p, err := newExecPushPuller(&backend.Location{
+		Kind:    "exec",
+		Options: map[string]string{"exec_plugin": "dir", "root": root},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error setting up plugin: %v", err)
+	}
+	return p, root
+}
+
+func TestExecPushPuller(t *testing.T) {
+	p, root := newTestExecPushPuller(t)
+	given := "contents streamed through a plugin"
+
+	if err := p.Push(t.Context(), strings.NewReader(given), "backups/a"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "backups", "a")); err != nil {
+		t.Fatalf("expected the plugin to store the object: %v", err)
+	}
+
+	r, err := p.Pull(t.Context(), "backups/a")
+	if err != nil {
+		t.Fatalf("unexpected error pulling: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	if diff := cmp.Diff(given, string(got)); diff != "" {
+		t.Errorf("pulled contents mismatch (-want +got):\n%s", diff)
+	}
+
+	paths, err := p.List(t.Context(), "backups/")
+	if err != nil {
+		t.Fatalf("unexpected error listing: %v", err)
+	}
+	if diff := cmp.Diff([]string{"backups/a"}, paths); diff != "" {
+		t.Errorf("listed paths mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := p.Delete(t.Context(), "backups/a"); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if err := p.Delete(t.Context(), "backups/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist deleting twice, got: %v", err)
+	}
+}
+
+func TestExecPushPuller_PullMissing(t *testing.T) {
+	p, _ := newTestExecPushPuller(t)
+
+	r, err := p.Pull(t.Context(), "missing")
+	if err != nil {
+		t.Fatalf("unexpected error starting pull: %v", err)
+	}
+	_, err = io.ReadAll(r)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected not exist, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "no object at missing") {
+		t.Errorf("expected the plugin's stderr in the error, got: %v", err)
+	}
+}
+
+func TestExecPushPuller_PushUnread(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test plugin is a shell script")
+	}
+	// A plugin that claims success without reading the object
+	plugin := filepath.Join(t.TempDir(), "volback-backend-lazy")
+	if err := os.WriteFile(plugin, []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatalf("unexpected error writing plugin: %v", err)
+	}
+	p, err := newExecPushPuller(&backend.Location{Kind: "exec", Options: map[string]string{"exec_plugin": plugin}})
+	if err != nil {
+		t.Fatalf("unexpected error setting up plugin: %v", err)
+	}
+
+	// More than a pipe buffer holds, so it can't all be written before the
+	// plugin exits
+	src := NewMemPushPuller()
+	src.Put("source", bytes.Repeat([]byte("unread "), 1<<20))
+	cfg := newTestConfig()
+	cfg.Progress = progress.FormatQuiet
+	e, err := NewExecutor(cfg, src, p)
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	_, err = e.Backup(t.Context())
+	if err == nil || !strings.Contains(err.Error(), "exited after reading only") {
+		t.Fatalf("expected the truncated push to fail, got: %v", err)
+	}
+}
+
+func TestExecPushPuller_RoundTrip(t *testing.T) {
+	p, _ := newTestExecPushPuller(t)
+	given := []byte("contents backed up to and restored from a plugin")
+
+	src := NewMemPushPuller()
+	src.Put("source", given)
+	cfg := newTestConfig()
+	backup, err := NewExecutor(cfg, src, p)
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	if _, err := backup.Backup(t.Context()); err != nil {
+		t.Fatalf("unexpected error backing up: %v", err)
+	}
+
+	restored := NewMemPushPuller()
+	cfg.Source.Path, cfg.Destination.Path = "backup", "restored"
+	restore, err := NewExecutor(cfg, p, restored)
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	if _, err := restore.Restore(t.Context()); err != nil {
+		t.Fatalf("unexpected error restoring: %v", err)
+	}
+
+	got, _ := restored.Get("restored")
+	if diff := cmp.Diff(given, got); diff != "" {
+		t.Errorf("restored contents mismatch (-want +got):\n%s", diff)
+	}
+}
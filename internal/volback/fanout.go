@@ -0,0 +1,132 @@
+package volback
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/jacobmiller22/volume-backup/internal/pipes"
+)
+
+// destination is a Pusher along with where it pushes to
+type destination struct {
+	kind   string
+	path   string
+	pusher Pusher
+	retry  RetryPolicy
+	// pipeline, if set, processes what is pushed to this destination alone,
+	// such as limiting its bandwidth
+	pipeline *pipes.IOPipeline
+}
+
+// errIncompletePush is recorded for a destination whose Pusher returned
+// without an error before reading everything it was given
+var errIncompletePush = errors.New("the destination stopped reading before the end of the backup")
+
+// push pushes r to the destination through its pipeline. A push that returns
+// before reading all of r fails with errIncompletePush, so a truncated backup
+// isn't reported as pushed.
+func (d destination) push(ctx context.Context, r io.Reader, metadata map[string]string) error {
+	if d.pipeline != nil {
+		r = d.pipeline.Execute(ctx, r)
+		// Stop the pipeline if the pusher returns without reading everything
+		if c, ok := r.(io.Closer); ok {
+			defer c.Close()
+		}
+	}
+	er := &eofReader{r: r}
+	if err := retryPush(ctx, d.retry, d.pusher, er, d.path, metadata); err != nil {
+		return err
+	}
+	if !er.eof {
+		return errIncompletePush
+	}
+	return nil
+}
+
+// eofReader records whether r has been read to the end
+type eofReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (e *eofReader) Read(b []byte) (int, error) {
+	n, err := e.r.Read(b)
+	if err == io.EOF {
+		e.eof = true
+	}
+	return n, err
+}
+
+// fanOut tees everything read from r to every destination's Pusher
+// concurrently. Each destination is fed through its own pipe, so the slowest
+// one sets the pace for all of them. A destination that fails is dropped
+// while the others carry on, and its error is recorded in its result. The
+// returned error is only set if r itself fails. metadata is pushed alongside
+// to destinations that can store it.
+func fanOut(ctx context.Context, r io.Reader, dsts []destination, metadata map[string]string) ([]DestinationResult, error) {
+	results := make([]DestinationResult, len(dsts))
+	writers := make([]*io.PipeWriter, len(dsts))
+
+	var wg sync.WaitGroup
+	for i, d := range dsts {
+		results[i] = DestinationResult{Kind: d.kind, Path: d.path}
+
+		pr, pw := io.Pipe()
+		writers[i] = pw
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].Err = d.push(ctx, pr, metadata)
+			// Unblock the writer if Push returned without reading everything
+			pr.Close()
+		}()
+	}
+
+	var readErr error
+	live := len(writers)
+	buf := make([]byte, 32*1024)
+	for live > 0 {
+		n, err := r.Read(buf)
+		for i, w := range writers {
+			if w == nil || n == 0 {
+				continue
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				// The pusher has stopped reading. Its own error is reported once
+				// Push returns.
+				writers[i] = nil
+				live--
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+
+	if live == 0 {
+		// Nobody is left to read the rest, so stop whatever is producing it
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	for _, w := range writers {
+		if w == nil {
+			continue
+		}
+		if readErr != nil {
+			w.CloseWithError(readErr)
+		} else {
+			w.Close()
+		}
+	}
+
+	wg.Wait()
+	return results, readErr
+}
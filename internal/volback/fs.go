@@ -1,21 +1,110 @@
 package volback
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
+	"github.com/jacobmiller22/volume-backup/backend"
 	"github.com/jacobmiller22/volume-backup/internal/zip"
 )
 
+func init() {
+	backend.Register("fs", backend.Backend{
+		NewPuller: func(_ context.Context, loc *backend.Location) (Puller, error) {
+			opts, err := decodeOptions[FsOptions](loc)
+			if err != nil {
+				return nil, err
+			}
+			return &FsPushPuller{
+				restore: loc.Restore,
+				archiveOpts: zip.ArchiveOptions{
+					OneFileSystem: opts.OneFileSystem,
+					Fifos:         zip.SpecialFilePolicy(opts.Fifos),
+					Sockets:       zip.SpecialFilePolicy(opts.Sockets),
+					Devices:       zip.SpecialFilePolicy(opts.Devices),
+					ReadErrors:    zip.ReadErrorPolicy(opts.OnReadError),
+				},
+			}, nil
+		},
+		NewPusher: func(_ context.Context, loc *backend.Location) (Pusher, error) {
+			return &FsPushPuller{restore: loc.Restore}, nil
+		},
+		Options:  &FsOptions{},
+		Validate: validateFsOptions,
+	})
+}
+
+// FsOptions configure how a filesystem source is walked while archiving
+type FsOptions struct {
+	OneFileSystem bool `json:"one_file_system" scope:"src" usage:"Don't descend into directories on other filesystems"`
+
+	// Policies for special files, one of "skip", "metadata" or "error"
+	Fifos   string `json:"fifos" scope:"src" usage:"How to handle FIFOs: skip, metadata or error"`
+	Sockets string `json:"sockets" scope:"src" usage:"How to handle sockets: skip, metadata or error"`
+	Devices string `json:"devices" scope:"src" usage:"How to handle device nodes: skip, metadata or error"`
+
+	// Either "fail" or "skip". Skipped files are reported as warnings
+	OnReadError string `json:"on_read_error" scope:"src" usage:"How to handle unreadable files: fail or skip"`
+
+	// Either "reflink" or "command", see the snapshot package
+	Snapshot               string `json:"snapshot" scope:"src" usage:"Archive from a point-in-time snapshot: reflink or command"`
+	SnapshotCommand        string `json:"snapshot_command" scope:"src" usage:"Command that creates the snapshot at $VOLBACK_SNAPSHOT_PATH"`
+	SnapshotCleanupCommand string `json:"snapshot_cleanup_command" scope:"src" usage:"Command that tears down the snapshot"`
+	SnapshotDir            string `json:"snapshot_dir" scope:"src" usage:"Directory to create the snapshot in"`
+	// SnapshotAllowCopy lets reflink snapshots copy file contents on
+	// filesystems that can't clone them, which takes as much space again as
+	// the source
+	SnapshotAllowCopy bool `json:"snapshot_allow_copy" scope:"src" usage:"Copy file contents into reflink snapshots when they can't be cloned"`
+}
+
+func validateFsOptions(loc *backend.Location) error {
+	opts, err := decodeOptions[FsOptions](loc)
+	if err != nil {
+		return err
+	}
+	for name, policy := range map[string]string{
+		"fifos":   opts.Fifos,
+		"sockets": opts.Sockets,
+		"devices": opts.Devices,
+	} {
+		switch policy {
+		case "", "skip", "metadata", "error":
+		default:
+			return fmt.Errorf("invalid %s policy %q, must be one of skip, metadata or error", name, policy)
+		}
+	}
+	switch opts.OnReadError {
+	case "", "fail", "skip":
+	default:
+		return fmt.Errorf("invalid on_read_error %q, must be one of fail or skip", opts.OnReadError)
+	}
+	switch opts.Snapshot {
+	case "":
+	case "reflink", "command":
+		if loc.Restore {
+			return fmt.Errorf("snapshots are only supported when backing up an fs source")
+		}
+		if opts.Snapshot == "command" && opts.SnapshotCommand == "" {
+			return fmt.Errorf("snapshot_command is required for command snapshots")
+		}
+	default:
+		return fmt.Errorf("invalid snapshot %q, must be one of reflink or command", opts.Snapshot)
+	}
+	return nil
+}
+
 type FsPushPuller struct {
-	restore bool
+	restore     bool
+	archiveOpts zip.ArchiveOptions
 }
 
 // Pull pulls the given path and returns an io.Reader that will read
 // zip-compressed contents if we are not restoring
-func (p *FsPushPuller) Pull(path string) (io.Reader, error) {
+func (p *FsPushPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
 
 	if p.restore {
 		fd, err := os.Open(path)
@@ -25,7 +114,7 @@ func (p *FsPushPuller) Pull(path string) (io.Reader, error) {
 		return fd, nil
 	}
 
-	r, err := zip.CreateArchiveFromPath(path)
+	r, err := zip.CreateArchiveFromPath(path, p.archiveOpts)
 	if err != nil {
 		return nil, fmt.Errorf("creating zip archive: %v", err)
 	}
@@ -34,7 +123,7 @@ func (p *FsPushPuller) Pull(path string) (io.Reader, error) {
 
 // Push pushs the given reader to the path. If restore is true,
 // the reader will be unpacked as a compress zip file to given the path
-func (p *FsPushPuller) Push(r io.Reader, path string) error {
+func (p *FsPushPuller) Push(ctx context.Context, r io.Reader, path string) error {
 
 	if p.restore {
 		return zip.UnpackArchiveToPath(r, path)
@@ -45,7 +134,7 @@ func (p *FsPushPuller) Push(r io.Reader, path string) error {
 		return err
 	}
 
-	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
@@ -57,3 +146,53 @@ func (p *FsPushPuller) Push(r io.Reader, path string) error {
 
 	return nil
 }
+
+// List returns every regular file below prefix, or prefix itself if it is a
+// file
+func (p *FsPushPuller) List(ctx context.Context, prefix string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(prefix, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// Stat describes the file at path. Files have no metadata.
+func (p *FsPushPuller) Stat(ctx context.Context, path string) (*ObjectInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	return &ObjectInfo{Size: info.Size()}, nil
+}
+
+// Size walks path, summing the sizes of the regular files below it. Files
+// that can't be read are left out, since the walk only feeds an estimate.
+func (p *FsPushPuller) Size(ctx context.Context, path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,65 @@
+package volback
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
+
+func TestGcsPushPuller(t *testing.T) {
+	srv, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme: "http",
+		Host:   "127.0.0.1",
+		Port:   0,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error starting fake gcs server: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+	srv.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: "backups"})
+
+	loc := &GcsOptions{
+		Gcs_Bucket:   "backups",
+		Gcs_Endpoint: srv.URL() + "/storage/v1/",
+		// The minimum chunk size, so the upload takes several requests
+		Gcs_ChunkSize: 256 * 1024,
+	}
+	gcsClient, err := newGcsClient(loc)
+	if err != nil {
+		t.Fatalf("unexpected error creating gcs client: %v", err)
+	}
+	p := &GcsPushPuller{gcsClient: gcsClient, bucket: loc.Gcs_Bucket, chunkSize: loc.Gcs_ChunkSize}
+
+	given := bytes.Repeat([]byte("0123456789abcdef"), 48*1024)
+	if err := p.Push(t.Context(), bytes.NewReader(given), "nested/backup.ct"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+
+	obj, err := srv.GetObject("backups", "nested/backup.ct")
+	if err != nil {
+		t.Fatalf("unexpected error reading object from fake server: %v", err)
+	}
+	if !bytes.Equal(given, obj.Content) {
+		t.Errorf("stored contents mismatch: got %d bytes, want %d", len(obj.Content), len(given))
+	}
+
+	r, err := p.Pull(t.Context(), "nested/backup.ct")
+	if err != nil {
+		t.Fatalf("unexpected error pulling: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error reading pulled object: %v", err)
+	}
+	r.(io.Closer).Close()
+
+	if !bytes.Equal(given, got) {
+		t.Errorf("pulled contents mismatch: got %d bytes, want %d", len(got), len(given))
+	}
+
+	if _, err := p.Pull(t.Context(), "nested/missing.ct"); err == nil {
+		t.Errorf("expected error pulling missing object, got nil")
+	}
+}
@@ -0,0 +1,191 @@
+package volback
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jacobmiller22/volume-backup/backend"
+)
+
+func init() {
+	backend.Register("http", backend.Backend{
+		NewPuller: func(_ context.Context, loc *backend.Location) (Puller, error) {
+			return newHttpPushPullerFromLocation(loc)
+		},
+		NewPusher: func(_ context.Context, loc *backend.Location) (Pusher, error) {
+			return newHttpPushPullerFromLocation(loc)
+		},
+		Options: &HttpOptions{},
+		Validate: func(loc *backend.Location) error {
+			opts, err := decodeOptions[HttpOptions](loc)
+			if err != nil {
+				return err
+			}
+			if opts.Http_Url == "" {
+				return fmt.Errorf("http_url is required")
+			}
+			if (opts.Http_ClientCert == "") != (opts.Http_ClientKey == "") {
+				return fmt.Errorf("http_client_cert and http_client_key must be set together")
+			}
+			return nil
+		},
+	})
+}
+
+// HttpOptions configure an http location
+type HttpOptions struct {
+	Http_Url         string            `json:"http_url" usage:"Base url that backup paths are resolved against"`
+	Http_Headers     map[string]string `json:"http_headers" flag:"http-header" sep:":" usage:"Header to send with every request as \"Name: value\", may be repeated"`
+	Http_BearerToken string            `json:"http_bearer_token" usage:"Bearer token sent with every request"`
+	Http_ClientCert  string            `json:"http_client_cert" usage:"Path to a PEM client certificate for mutual TLS"`
+	Http_ClientKey   string            `json:"http_client_key" usage:"Path to the PEM key for the client certificate"`
+	Http_CaCert      string            `json:"http_ca_cert" usage:"Path to a PEM CA bundle used to verify the server"`
+}
+
+func newHttpPushPullerFromLocation(loc *backend.Location) (*HttpPushPuller, error) {
+	opts, err := decodeOptions[HttpOptions](loc)
+	if err != nil {
+		return nil, err
+	}
+	return newHttpPushPuller(opts)
+}
+
+// newTLSConfig loads an optional client certificate and CA bundle. A nil
+// config is returned when neither is set, so the defaults apply.
+func newTLSConfig(certPath, keyPath, caPath string) (*tls.Config, error) {
+	if certPath == "" && caPath == "" {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{}
+
+	if certPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if caPath != "" {
+		pem, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caPath)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	return tlsCfg, nil
+}
+
+// Timeouts of the transports HTTP based backends use. Nothing bounds how long
+// a body takes to stream, only how long a server takes to connect and to
+// respond once a request has been sent. Servers can take a while to store a
+// large upload before they respond.
+const (
+	httpDialTimeout           = 30 * time.Second
+	httpTLSHandshakeTimeout   = 10 * time.Second
+	httpResponseHeaderTimeout = 5 * time.Minute
+)
+
+// newHTTPTransport returns a transport with connect and response timeouts,
+// using tlsCfg if it isn't nil
+func newHTTPTransport(tlsCfg *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: httpDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = httpTLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = httpResponseHeaderTimeout
+	if tlsCfg != nil {
+		transport.TLSClientConfig = tlsCfg
+	}
+	return transport
+}
+
+// expectStatus closes the response and returns an error unless its status is
+// one of ok
+func expectStatus(resp *http.Response, ok ...int) error {
+	defer resp.Body.Close()
+	if slices.Contains(ok, resp.StatusCode) {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func newHttpPushPuller(opts *HttpOptions) (*HttpPushPuller, error) {
+	baseURL, err := url.Parse(opts.Http_Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http url: %w", err)
+	}
+
+	tlsCfg, err := newTLSConfig(opts.Http_ClientCert, opts.Http_ClientKey, opts.Http_CaCert)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	for k, v := range opts.Http_Headers {
+		header.Set(k, v)
+	}
+	if opts.Http_BearerToken != "" {
+		header.Set("Authorization", "Bearer "+opts.Http_BearerToken)
+	}
+
+	return &HttpPushPuller{
+		client:  &http.Client{Transport: newHTTPTransport(tlsCfg)},
+		baseURL: baseURL,
+		header:  header,
+	}, nil
+}
+
+// HttpPushPuller pushes backups with a streaming PUT and pulls them with GET,
+// for endpoints that speak nothing more than plain HTTP
+type HttpPushPuller struct {
+	client  *http.Client
+	baseURL *url.URL
+	header  http.Header
+}
+
+func (p *HttpPushPuller) do(ctx context.Context, method string, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL.JoinPath(path).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range p.header {
+		req.Header[k] = v
+	}
+	return p.client.Do(req)
+}
+
+func (p *HttpPushPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	resp, err := p.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s over http: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, expectStatus(resp, http.StatusOK)
+	}
+	return resp.Body, nil
+}
+
+func (p *HttpPushPuller) Push(ctx context.Context, r io.Reader, path string) error {
+	resp, err := p.do(ctx, http.MethodPut, path, r)
+	if err != nil {
+		return fmt.Errorf("failed to put %s over http: %w", path, err)
+	}
+	return expectStatus(resp, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
@@ -0,0 +1,143 @@
+package volback
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeClientCert writes a self signed client certificate and its key as PEM
+// files and returns their paths
+func writeClientCert(t *testing.T) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating client key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "volback"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error creating client certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unexpected error parsing client certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling client key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("unexpected error writing client certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("unexpected error writing client key: %v", err)
+	}
+	return certPath, keyPath, cert
+}
+
+func TestHttpPushPuller(t *testing.T) {
+	certPath, keyPath, clientCert := writeClientCert(t)
+
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Volback-Test") != "yes" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = body
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(body)
+		}
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.RequireAndVerifyClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600); err != nil {
+		t.Fatalf("unexpected error writing CA bundle: %v", err)
+	}
+
+	p, err := newHttpPushPuller(&HttpOptions{
+		Http_Url:         srv.URL + "/artifacts",
+		Http_Headers:     map[string]string{"X-Volback-Test": "yes"},
+		Http_BearerToken: "token",
+		Http_ClientCert:  certPath,
+		Http_ClientKey:   keyPath,
+		Http_CaCert:      caPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating http push puller: %v", err)
+	}
+
+	given := "backup contents sent over https"
+	if err := p.Push(t.Context(), strings.NewReader(given), "backups/backup.ct"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+
+	r, err := p.Pull(t.Context(), "backups/backup.ct")
+	if err != nil {
+		t.Fatalf("unexpected error pulling: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error reading pulled body: %v", err)
+	}
+	r.(io.Closer).Close()
+
+	if diff := cmp.Diff(given, string(got)); diff != "" {
+		t.Errorf("pulled contents mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := p.Pull(t.Context(), "backups/missing.ct"); err == nil {
+		t.Errorf("expected error pulling missing object, got nil")
+	}
+}
@@ -0,0 +1,199 @@
+package volback
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobmiller22/volume-backup/backend"
+)
+
+func init() {
+	backend.Register("mem", backend.Backend{
+		NewPuller: func(context.Context, *backend.Location) (Puller, error) { return DefaultMemPushPuller, nil },
+		NewPusher: func(context.Context, *backend.Location) (Pusher, error) { return DefaultMemPushPuller, nil },
+	})
+}
+
+// ErrInjected is the default error returned by MemFaults
+var ErrInjected = errors.New("injected fault")
+
+// MemFaults are failures a MemPushPuller injects to exercise error paths.
+// The zero value injects nothing.
+type MemFaults struct {
+	// PullErr is returned by readers from Pull once PullErrAfter bytes have
+	// been read
+	PullErr      error
+	PullErrAfter int64
+
+	// PushErr is returned by Push once it has read PushErrAfter bytes, and
+	// nothing is stored
+	PushErr      error
+	PushErrAfter int64
+
+	// ReadDelay is slept before every read from a reader returned by Pull
+	ReadDelay time.Duration
+}
+
+// MemPushPuller stores objects in memory. It is safe for concurrent use, and
+// is mostly useful for testing the executor without real storage.
+type MemPushPuller struct {
+	mu       sync.RWMutex
+	objects  map[string][]byte
+	metadata map[string]map[string]string
+	faults   MemFaults
+}
+
+// DefaultMemPushPuller backs the "mem" location kind, so objects pushed by one
+// executor can be pulled by another in the same process
+var DefaultMemPushPuller = NewMemPushPuller()
+
+func NewMemPushPuller() *MemPushPuller {
+	return &MemPushPuller{objects: map[string][]byte{}, metadata: map[string]map[string]string{}}
+}
+
+// SetFaults replaces the faults injected into subsequent Pulls and Pushes
+func (p *MemPushPuller) SetFaults(faults MemFaults) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.faults = faults
+}
+
+// Get returns a copy of the object stored at path
+func (p *MemPushPuller) Get(path string) ([]byte, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	data, ok := p.objects[path]
+	return bytes.Clone(data), ok
+}
+
+// Put stores a copy of data at path
+func (p *MemPushPuller) Put(path string, data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.objects[path] = bytes.Clone(data)
+	delete(p.metadata, path)
+}
+
+// List returns the path of every object that starts with prefix, sorted
+func (p *MemPushPuller) List(ctx context.Context, prefix string) ([]string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var paths []string
+	for path := range p.objects {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+func (p *MemPushPuller) Stat(ctx context.Context, path string) (*ObjectInfo, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	data, ok := p.objects[path]
+	if !ok {
+		return nil, fmt.Errorf("stat %s: %w", path, fs.ErrNotExist)
+	}
+	return &ObjectInfo{Size: int64(len(data)), Metadata: maps.Clone(p.metadata[path])}, nil
+}
+
+func (p *MemPushPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	p.mu.RLock()
+	data, ok := p.objects[path]
+	faults := p.faults
+	p.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("pulling %s: %w", path, fs.ErrNotExist)
+	}
+
+	return &faultyReader{
+		r:        bytes.NewReader(data),
+		err:      faults.PullErr,
+		errAfter: faults.PullErrAfter,
+		delay:    faults.ReadDelay,
+	}, nil
+}
+
+func (p *MemPushPuller) Push(ctx context.Context, r io.Reader, path string) error {
+	return p.PushWithMetadata(ctx, r, path, nil)
+}
+
+// PushWithMetadata stores metadata alongside the object, replacing any that
+// was there before
+func (p *MemPushPuller) PushWithMetadata(ctx context.Context, r io.Reader, path string, metadata map[string]string) error {
+	p.mu.RLock()
+	faults := p.faults
+	p.mu.RUnlock()
+
+	data, err := io.ReadAll(&faultyReader{
+		r:        r,
+		err:      faults.PushErr,
+		errAfter: faults.PushErrAfter,
+	})
+	if err != nil {
+		return fmt.Errorf("pushing %s: %w", path, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.objects[path] = data
+	if len(metadata) > 0 {
+		p.metadata[path] = maps.Clone(metadata)
+	} else {
+		delete(p.metadata, path)
+	}
+	return nil
+}
+
+// Delete removes the object at path along with its metadata
+func (p *MemPushPuller) Delete(ctx context.Context, path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.objects[path]; !ok {
+		return fmt.Errorf("deleting %s: %w", path, fs.ErrNotExist)
+	}
+	delete(p.objects, path)
+	delete(p.metadata, path)
+	return nil
+}
+
+// faultyReader returns err once errAfter bytes have been read from r, and
+// sleeps for delay before every read
+type faultyReader struct {
+	r        io.Reader
+	read     int64
+	err      error
+	errAfter int64
+	delay    time.Duration
+}
+
+func (fr *faultyReader) Read(b []byte) (int, error) {
+	if fr.delay > 0 {
+		time.Sleep(fr.delay)
+	}
+	if fr.err == nil {
+		return fr.r.Read(b)
+	}
+
+	remaining := fr.errAfter - fr.read
+	if remaining <= 0 {
+		return 0, fr.err
+	}
+	if int64(len(b)) > remaining {
+		b = b[:remaining]
+	}
+	n, err := fr.r.Read(b)
+	fr.read += int64(n)
+	return n, err
+}
@@ -1,35 +1,20 @@
 package volback
 
 import (
+	"context"
 	"fmt"
-	"io"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jacobmiller22/volume-backup/backend"
 	"github.com/jacobmiller22/volume-backup/internal/config"
 )
 
-type Puller interface {
-	// download
-	Pull(path string) (io.Reader, error)
-}
-
-func pullerFromConfig(cfg *config.Config) (Puller, error) {
-	switch cfg.Source.Kind {
-	case "s3":
-		awsCfg, err := newAwsCfg(&cfg.Source)
-		if err != nil {
-			return nil, err
-		}
-
-		return &S3PushPuller{
-			s3client: s3.NewFromConfig(*awsCfg, func(o *s3.Options) { o.UsePathStyle = cfg.S3ForcePathStyle }),
-			bucket:   cfg.Source.S3_Bucket,
-		}, nil
-	case "fs":
-		return &FsPushPuller{
-			restore: cfg.Restore,
-		}, nil
-	default:
-		return nil, fmt.Errorf("invalid source kind")
+func pullerFromConfig(ctx context.Context, cfg *config.Config) (Puller, error) {
+	b, ok := backend.Lookup(cfg.Source.Kind)
+	if !ok {
+		return nil, fmt.Errorf("invalid source kind %q", cfg.Source.Kind)
+	}
+	if b.NewPuller == nil {
+		return nil, fmt.Errorf("%s can't be used as a source", cfg.Source.Kind)
 	}
+	return b.NewPuller(ctx, backendLocation(cfg, &cfg.Source))
 }
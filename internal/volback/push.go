@@ -1,35 +1,36 @@
 package volback
 
 import (
+	"context"
 	"fmt"
-	"io"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jacobmiller22/volume-backup/backend"
 	"github.com/jacobmiller22/volume-backup/internal/config"
 )
 
-type Pusher interface {
-	// upload
-	Push(r io.Reader, path string) error
+// pusherFromConfig creates a Pusher for dst, which is one of cfg's destinations
+func pusherFromConfig(ctx context.Context, cfg *config.Config, dst *config.Location) (Pusher, error) {
+	b, ok := backend.Lookup(dst.Kind)
+	if !ok {
+		return nil, fmt.Errorf("invalid destination kind %q", dst.Kind)
+	}
+	if b.NewPusher == nil {
+		return nil, fmt.Errorf("%s can't be used as a destination", dst.Kind)
+	}
+	return b.NewPusher(ctx, backendLocation(cfg, dst))
 }
 
-func pusherFromConfig(cfg *config.Config) (Pusher, error) {
-
-	switch cfg.Destination.Kind {
-	case "s3":
-
-		awsCfg, err := newAwsCfg(&cfg.Destination)
-		if err != nil {
-			return nil, err
-		}
-
-		return &S3PushPuller{
-			s3client: s3.NewFromConfig(*awsCfg, func(o *s3.Options) { o.UsePathStyle = cfg.S3ForcePathStyle }),
-			bucket:   cfg.Destination.S3_Bucket,
-		}, nil
-	case "fs":
-		return &FsPushPuller{restore: cfg.Restore}, nil
-	default:
-		return nil, fmt.Errorf("invalid source kind")
+// AbortStaleUploadsFromConfig aborts the uploads below the destination's path
+// that were started more than olderThan ago
+func AbortStaleUploadsFromConfig(ctx context.Context, cfg *config.Config, olderThan time.Duration) ([]string, error) {
+	pusher, err := pusherFromConfig(ctx, cfg, &cfg.Destination)
+	if err != nil {
+		return nil, err
+	}
+	aborter, ok := pusher.(StaleUploadAborter)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't upload in parts", cfg.Destination.Kind)
 	}
+	return aborter.AbortStaleUploads(ctx, cfg.Destination.Path, olderThan)
 }
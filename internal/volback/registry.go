@@ -0,0 +1,51 @@
+package volback
+
+import (
+	"maps"
+
+	"github.com/jacobmiller22/volume-backup/backend"
+	"github.com/jacobmiller22/volume-backup/internal/config"
+)
+
+// The interfaces backends implement live in the public backend package, so
+// backends outside this module can be registered
+type (
+	Puller             = backend.Puller
+	Pusher             = backend.Pusher
+	Sizer              = backend.Sizer
+	StaleUploadAborter = backend.StaleUploadAborter
+	ObjectInfo         = backend.ObjectInfo
+	Lister             = backend.Lister
+	Stater             = backend.Stater
+	MetadataPusher     = backend.MetadataPusher
+	Deleter            = backend.Deleter
+)
+
+// backendLocation is loc, one of cfg's locations, as its backend sees it
+func backendLocation(cfg *config.Config, loc *config.Location) *backend.Location {
+	options := loc.Options
+	// S3_FORCE_PATH_STYLE only sets the default, the location's own option wins
+	if _, ok := options["s3_force_path_style"]; loc.Kind == "s3" && cfg.S3ForcePathStyle && !ok {
+		options = maps.Clone(options)
+		if options == nil {
+			options = map[string]string{}
+		}
+		options["s3_force_path_style"] = "true"
+	}
+	return &backend.Location{
+		Kind:    loc.Kind,
+		Path:    loc.Path,
+		Restore: cfg.Restore,
+		Retry:   retryPolicyFromConfig(loc),
+		Options: options,
+	}
+}
+
+// decodeOptions decodes loc's options into a new T
+func decodeOptions[T any](loc *backend.Location) (*T, error) {
+	var opts T
+	if err := loc.Decode(&opts); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
@@ -0,0 +1,176 @@
+package volback
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jacobmiller22/volume-backup/backend"
+	"github.com/jacobmiller22/volume-backup/internal/config"
+)
+
+// prefixedOptions configure the test-prefixed kind
+type prefixedOptions struct {
+	Prefix string `json:"prefix" scope:"dst" usage:"Prefix to push below"`
+}
+
+func init() {
+	// Registered like an out-of-tree backend would be, with only the public
+	// backend package
+	backend.Register("test-prefixed", backend.Backend{
+		NewPusher: func(_ context.Context, loc *backend.Location) (backend.Pusher, error) {
+			var opts prefixedOptions
+			if err := loc.Decode(&opts); err != nil {
+				return nil, err
+			}
+			return &prefixedPusher{prefix: opts.Prefix, mem: NewMemPushPuller()}, nil
+		},
+		Options: &prefixedOptions{},
+		Validate: func(loc *backend.Location) error {
+			var opts prefixedOptions
+			if err := loc.Decode(&opts); err != nil {
+				return err
+			}
+			if opts.Prefix == "" {
+				return fmt.Errorf("option prefix is required")
+			}
+			return nil
+		},
+	})
+}
+
+type prefixedPusher struct {
+	prefix string
+	mem    *MemPushPuller
+}
+
+func (p *prefixedPusher) Push(ctx context.Context, r io.Reader, path string) error {
+	return p.mem.Push(ctx, r, p.prefix+path)
+}
+
+func TestRegister_Validate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		dst     config.Location
+		wantErr string
+	}{
+		{
+			name: "valid",
+			dst:  config.Location{Kind: "test-prefixed", Options: map[string]string{"prefix": "p/"}},
+		},
+		{
+			name:    "missing option",
+			dst:     config.Location{Kind: "test-prefixed"},
+			wantErr: "option prefix is required",
+		},
+		{
+			name:    "unknown option",
+			dst:     config.Location{Kind: "test-prefixed", Options: map[string]string{"prefix": "p/", "other": "x"}},
+			wantErr: `unknown option "other"`,
+		},
+		{
+			name:    "other backend's option",
+			dst:     config.Location{Kind: "test-prefixed", Options: map[string]string{"prefix": "p/", "s3_bucket": "backups"}},
+			wantErr: `unknown option "s3_bucket"`,
+		},
+		{
+			name:    "builtin validation",
+			dst:     config.Location{Kind: "gcs"},
+			wantErr: "gcs_bucket is required",
+		},
+		{
+			name:    "unknown kind",
+			dst:     config.Location{Kind: "nope"},
+			wantErr: `unknown kind "nope"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.Destination = tc.dst
+
+			err := cfg.Validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRegister_Factory(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Destination = config.Location{Kind: "test-prefixed", Path: "backup", Options: map[string]string{"prefix": "p/"}}
+
+	pusher, err := pusherFromConfig(t.Context(), cfg, &cfg.Destination)
+	if err != nil {
+		t.Fatalf("unexpected error creating pusher: %v", err)
+	}
+	if err := pusher.Push(t.Context(), strings.NewReader("data"), "backup"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+	got, _ := pusher.(*prefixedPusher).mem.Get("p/backup")
+	if diff := cmp.Diff([]byte("data"), got); diff != "" {
+		t.Errorf("pushed contents mismatch (-want +got):\n%s", diff)
+	}
+
+	// Only registered as a destination
+	cfg.Source = cfg.Destination
+	if _, err := pullerFromConfig(t.Context(), cfg); err == nil {
+		t.Errorf("expected an error using a pusher only kind as a source")
+	}
+}
+
+func TestRegister_Flags(t *testing.T) {
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := config.ConfigFromFlagset(flagset, []string{"-dst.kind", "test-prefixed", "-dst.prefix", "p/"})
+	if err != nil {
+		t.Fatalf("unexpected error parsing flags: %v", err)
+	}
+	if diff := cmp.Diff(map[string]string{"prefix": "p/"}, cfg.Destination.Options); diff != "" {
+		t.Errorf("options mismatch (-want +got):\n%s", diff)
+	}
+
+	// The option only applies to destinations
+	if flagset.Lookup("src.prefix") != nil {
+		t.Errorf("expected no src.prefix flag")
+	}
+}
+
+func TestBackendLocation_ForcePathStyle(t *testing.T) {
+	testCases := []struct {
+		name      string
+		kind      string
+		pathStyle bool
+		options   map[string]string
+		want      map[string]string
+	}{
+		{name: "default", kind: "s3"},
+		{name: "from environment", kind: "s3", pathStyle: true, want: map[string]string{"s3_force_path_style": "true"}},
+		{name: "option overrides environment", kind: "s3", pathStyle: true, options: map[string]string{"s3_force_path_style": "false"}, want: map[string]string{"s3_force_path_style": "false"}},
+		{name: "other kinds", kind: "fs", pathStyle: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Destination:      config.Location{Kind: tc.kind, Options: tc.options},
+				S3ForcePathStyle: tc.pathStyle,
+			}
+			loc := backendLocation(cfg, &cfg.Destination)
+			if diff := cmp.Diff(tc.want, loc.Options); diff != "" {
+				t.Errorf("options mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package volback
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"strings"
+
+	"github.com/jacobmiller22/volume-backup/internal/config"
+)
+
+// ChecksumMetadataKey is the metadata key an object's hex encoded SHA-256 is
+// recorded under when it is replicated, so later runs can compare objects
+// without reading them again
+const ChecksumMetadataKey = "volback-sha256"
+
+// Replicator copies existing backups from one location to another as is,
+// without decrypting or otherwise transforming them
+type Replicator struct {
+	puller    Puller
+	srcPrefix string
+
+	pusher    Pusher
+	dstPrefix string
+	// dstPuller reads objects back from the destination to checksum them. It
+	// is nil if the destination can't be read.
+	dstPuller Puller
+
+	srcRetry RetryPolicy
+	dstRetry RetryPolicy
+}
+
+// ReplicateResult lists the source paths that were replicated
+type ReplicateResult struct {
+	Copied []string
+	// Skipped objects already existed at the destination with the same checksum
+	Skipped []string
+}
+
+func NewReplicatorFromConfig(ctx context.Context, cfg *config.Config) (*Replicator, error) {
+	// Backups are read back and written out as is, so an fs source must not
+	// archive and an fs destination must not unpack
+	srcCfg, dstCfg := *cfg, *cfg
+	srcCfg.Restore, dstCfg.Restore = true, false
+
+	var errs []error
+	puller, err := pullerFromConfig(ctx, &srcCfg)
+	errs = append(errs, err)
+	pusher, err := pusherFromConfig(ctx, &dstCfg, &cfg.Destination)
+	errs = append(errs, err)
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	r := NewReplicator(puller, cfg.Source.Path, pusher, cfg.Destination.Path)
+	r.srcRetry = retryPolicyFromConfig(&cfg.Source)
+	r.dstRetry = retryPolicyFromConfig(&cfg.Destination)
+
+	// An fs pusher would archive the objects it is asked to read back, so
+	// read them with a puller that doesn't
+	if cfg.Destination.Kind == "fs" {
+		r.dstPuller = &FsPushPuller{restore: true}
+	}
+
+	return r, nil
+}
+
+// NewReplicator creates a Replicator that copies every object below srcPrefix
+// in puller to pusher. Each object's path has srcPrefix replaced with
+// dstPrefix.
+func NewReplicator(puller Puller, srcPrefix string, pusher Pusher, dstPrefix string) *Replicator {
+	dstPuller, _ := pusher.(Puller)
+	return &Replicator{
+		puller:    puller,
+		srcPrefix: srcPrefix,
+		pusher:    pusher,
+		dstPrefix: dstPrefix,
+		dstPuller: dstPuller,
+	}
+}
+
+// Replicate copies every object below the source prefix that doesn't already
+// exist at the destination with the same checksum. A failure to copy one
+// object doesn't stop the others from being copied.
+func (r *Replicator) Replicate(ctx context.Context) (*ReplicateResult, error) {
+	lister, ok := r.puller.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("source kind doesn't support listing objects")
+	}
+
+	paths, err := retryList(ctx, r.srcRetry, lister, r.srcPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %w", r.srcPrefix, err)
+	}
+
+	result := &ReplicateResult{}
+	var errs []error
+	for _, srcPath := range paths {
+		// Objects that were already copied are intact, so stopping between
+		// them leaves nothing half done
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		dstPath := r.dstPrefix + strings.TrimPrefix(srcPath, r.srcPrefix)
+
+		copied, err := r.replicate(ctx, srcPath, dstPath)
+		if err != nil {
+			log.Printf("Failed to replicate %s to %s: %v\n", srcPath, dstPath, err)
+			errs = append(errs, fmt.Errorf("%s: %w", srcPath, err))
+			continue
+		}
+		if copied {
+			log.Printf("Replicated %s to %s\n", srcPath, dstPath)
+			result.Copied = append(result.Copied, srcPath)
+		} else {
+			log.Printf("Skipping %s, it is already at %s\n", srcPath, dstPath)
+			result.Skipped = append(result.Skipped, srcPath)
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// replicate copies a single object unless the destination already has it,
+// and reports whether it was copied
+func (r *Replicator) replicate(ctx context.Context, srcPath, dstPath string) (bool, error) {
+	info, err := retryStat(ctx, r.srcRetry, r.puller, srcPath)
+	if err != nil {
+		return false, err
+	}
+	var metadata map[string]string
+	if info != nil {
+		metadata = info.Metadata
+	}
+	// Sources that record no checksum have to be read to compare them with
+	// the destination. The checksum is pushed alongside the object, so it
+	// has to be known before the push starts either way.
+	sum := metadata[ChecksumMetadataKey]
+	if sum == "" {
+		if sum, err = checksum(ctx, r.puller, r.srcRetry, srcPath); err != nil {
+			return false, err
+		}
+	}
+
+	if same, err := r.hasCopy(ctx, dstPath, sum); err != nil {
+		return false, err
+	} else if same {
+		return false, nil
+	}
+
+	rd, err := retryPull(ctx, r.srcRetry, r.puller, srcPath)
+	if err != nil {
+		return false, err
+	}
+	if c, ok := rd.(io.Closer); ok {
+		defer c.Close()
+	}
+	vr := &verifyingReader{r: rd, h: sha256.New(), want: sum, path: srcPath}
+
+	metadata = maps.Clone(metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[ChecksumMetadataKey] = sum
+	err = retryPush(ctx, r.dstRetry, r.pusher, vr, dstPath, metadata)
+	if err == nil && !vr.verified {
+		err = fmt.Errorf("%w: the destination stopped reading before the end of the object", errChecksumMismatch)
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		r.discard(ctx, dstPath)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// discard removes what a failed copy may have left at path, so it isn't
+// mistaken for a good copy by a later run
+func (r *Replicator) discard(ctx context.Context, path string) {
+	d, ok := r.pusher.(Deleter)
+	if !ok {
+		return
+	}
+	if err := d.Delete(context.WithoutCancel(ctx), path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove bad copy at %s: %v\n", path, err)
+	}
+}
+
+// hasCopy reports whether the destination has an object at path with the
+// given checksum. Destinations that can't be inspected never do.
+func (r *Replicator) hasCopy(ctx context.Context, path, sum string) (bool, error) {
+	if _, ok := r.pusher.(Stater); !ok {
+		return false, nil
+	}
+
+	info, err := retryStat(ctx, r.dstRetry, r.pusher, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	dstSum := info.Metadata[ChecksumMetadataKey]
+	if dstSum == "" {
+		if r.dstPuller == nil {
+			return false, nil
+		}
+		if dstSum, err = checksum(ctx, r.dstPuller, r.dstRetry, path); err != nil {
+			return false, err
+		}
+	}
+	return dstSum == sum, nil
+}
+
+// errChecksumMismatch is returned when the bytes copied don't match the
+// checksum recorded for them
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// verifyingReader hashes everything read through it. Once r is exhausted it
+// fails the read with errChecksumMismatch instead of returning io.EOF if the
+// hash isn't want, so whatever is reading it doesn't accept a bad copy.
+type verifyingReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want string
+	path string
+	// verified is set once all of r has been read and matched want
+	verified bool
+}
+
+func (v *verifyingReader) Read(b []byte) (int, error) {
+	n, err := v.r.Read(b)
+	v.h.Write(b[:n])
+	if err == io.EOF {
+		if got := hexSum(v.h); got != v.want {
+			return n, fmt.Errorf("%w for %s, expected %s but read %s", errChecksumMismatch, v.path, v.want, got)
+		}
+		v.verified = true
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	if c, ok := v.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// checksum reads the object at path to compute its checksum
+func checksum(ctx context.Context, puller Puller, retry RetryPolicy, path string) (string, error) {
+	r, err := retryPull(ctx, retry, puller, path)
+	if err != nil {
+		return "", err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return hexSum(h), nil
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
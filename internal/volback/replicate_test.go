@@ -0,0 +1,212 @@
+package volback
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jacobmiller22/volume-backup/internal/config"
+)
+
+func TestReplicator_Replicate(t *testing.T) {
+	src := NewMemPushPuller()
+	src.Put("backups/a", []byte("first encrypted backup"))
+	src.Put("backups/b", []byte("second encrypted backup"))
+	src.Put("other/c", []byte("not below the prefix"))
+	src.PushWithMetadata(t.Context(), strings.NewReader(""), "backups/c", map[string]string{"owner": "volback"})
+
+	dst := NewMemPushPuller()
+	r := NewReplicator(src, "backups/", dst, "replica/")
+
+	result, err := r.Replicate(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error replicating: %v", err)
+	}
+	if diff := cmp.Diff([]string{"backups/a", "backups/b", "backups/c"}, result.Copied); diff != "" {
+		t.Errorf("copied mismatch (-want +got):\n%s", diff)
+	}
+
+	got, _ := dst.Get("replica/a")
+	if diff := cmp.Diff([]byte("first encrypted backup"), got); diff != "" {
+		t.Errorf("replicated contents mismatch (-want +got):\n%s", diff)
+	}
+	if _, ok := dst.Get("replica/c"); !ok {
+		t.Errorf("expected empty object to be replicated")
+	}
+	info, err := dst.Stat(t.Context(), "replica/c")
+	if err != nil {
+		t.Fatalf("unexpected error stating replica: %v", err)
+	}
+	if info.Metadata["owner"] != "volback" {
+		t.Errorf("expected metadata to be preserved, got: %v", info.Metadata)
+	}
+	if info.Metadata[ChecksumMetadataKey] == "" {
+		t.Errorf("expected checksum to be recorded, got: %v", info.Metadata)
+	}
+
+	// Change one object, the rest are already there
+	src.Put("backups/b", []byte("second encrypted backup, rotated"))
+	result, err = r.Replicate(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error replicating again: %v", err)
+	}
+	if diff := cmp.Diff([]string{"backups/b"}, result.Copied); diff != "" {
+		t.Errorf("copied mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"backups/a", "backups/c"}, result.Skipped); diff != "" {
+		t.Errorf("skipped mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReplicator_PushError(t *testing.T) {
+	src := NewMemPushPuller()
+	src.Put("backups/a", []byte("an encrypted backup"))
+	dst := NewMemPushPuller()
+	dst.SetFaults(MemFaults{PushErr: ErrInjected})
+
+	_, err := NewReplicator(src, "backups/", dst, "replica/").Replicate(t.Context())
+	if !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected injected error, got: %v", err)
+	}
+}
+
+func TestReplicator_ChecksumMismatch(t *testing.T) {
+	src := NewMemPushPuller()
+	// The recorded checksum doesn't match the contents, as if the object was
+	// corrupted after it was pushed
+	src.PushWithMetadata(t.Context(), strings.NewReader("corrupted backup"), "backups/a", map[string]string{
+		ChecksumMetadataKey: strings.Repeat("0", 64),
+	})
+	dst := NewMemPushPuller()
+	r := NewReplicator(src, "backups/", dst, "replica/")
+
+	for range 2 {
+		// The bad copy must not be kept, or the next run would skip it
+		if _, err := r.Replicate(t.Context()); !errors.Is(err, errChecksumMismatch) {
+			t.Fatalf("expected checksum mismatch, got: %v", err)
+		}
+		if _, ok := dst.Get("replica/a"); ok {
+			t.Fatalf("expected the bad copy not to be kept")
+		}
+	}
+}
+
+// shortPusher stores the first few bytes of every object and reports
+// success without reading the rest
+type shortPusher struct {
+	*MemPushPuller
+}
+
+func (p *shortPusher) PushWithMetadata(ctx context.Context, r io.Reader, path string, metadata map[string]string) error {
+	return p.MemPushPuller.PushWithMetadata(ctx, io.LimitReader(r, 4), path, metadata)
+}
+
+func TestReplicator_ShortPush(t *testing.T) {
+	src := NewMemPushPuller()
+	src.Put("backups/a", []byte("an encrypted backup"))
+	dst := &shortPusher{NewMemPushPuller()}
+
+	_, err := NewReplicator(src, "backups/", dst, "replica/").Replicate(t.Context())
+	if !errors.Is(err, errChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got: %v", err)
+	}
+	if _, ok := dst.Get("replica/a"); ok {
+		t.Errorf("expected the short copy to be deleted")
+	}
+}
+
+// countingPuller counts the pulls made through it
+type countingPuller struct {
+	*MemPushPuller
+	pulls int
+}
+
+func (p *countingPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	p.pulls++
+	return p.MemPushPuller.Pull(ctx, path)
+}
+
+func TestReplicator_NoSourceChecksum(t *testing.T) {
+	src := &countingPuller{MemPushPuller: NewMemPushPuller()}
+	src.Put("backups/a", []byte("a backup stored without a checksum"))
+	dst := NewMemPushPuller()
+	r := NewReplicator(src, "backups/", dst, "replica/")
+
+	if _, err := r.Replicate(t.Context()); err != nil {
+		t.Fatalf("unexpected error replicating: %v", err)
+	}
+	info, err := dst.Stat(t.Context(), "replica/a")
+	if err != nil {
+		t.Fatalf("unexpected error stating replica: %v", err)
+	}
+	if info.Metadata[ChecksumMetadataKey] == "" {
+		t.Errorf("expected the checksum of the copy to be recorded")
+	}
+
+	// The source is checksummed to recognise the copy, but not copied again
+	src.pulls = 0
+	result, err := r.Replicate(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error replicating again: %v", err)
+	}
+	if diff := cmp.Diff([]string{"backups/a"}, result.Skipped); diff != "" {
+		t.Errorf("skipped mismatch (-want +got):\n%s", diff)
+	}
+	if src.pulls != 1 {
+		t.Errorf("expected the source to be read once to checksum it, got %d pulls", src.pulls)
+	}
+
+	// Overwriting the source with the same size still replicates it
+	src.Put("backups/a", []byte("A BACKUP STORED WITHOUT A CHECKSUM"))
+	result, err = r.Replicate(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error replicating the overwritten source: %v", err)
+	}
+	if diff := cmp.Diff([]string{"backups/a"}, result.Copied); diff != "" {
+		t.Errorf("copied mismatch (-want +got):\n%s", diff)
+	}
+	got, _ := dst.Get("replica/a")
+	if diff := cmp.Diff([]byte("A BACKUP STORED WITHOUT A CHECKSUM"), got); diff != "" {
+		t.Errorf("replicated contents mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewReplicatorFromConfig_Fs(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.enc"), []byte("an encrypted backup"), 0644); err != nil {
+		t.Fatalf("unexpected error writing backup: %v", err)
+	}
+
+	cfg := &config.Config{
+		Source:      config.Location{Kind: "fs", Path: src},
+		Destination: config.Location{Kind: "fs", Path: dst},
+	}
+	r, err := NewReplicatorFromConfig(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error setting up replicator: %v", err)
+	}
+
+	if _, err := r.Replicate(t.Context()); err != nil {
+		t.Fatalf("unexpected error replicating: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dst, "a.enc"))
+	if err != nil {
+		t.Fatalf("unexpected error reading replica: %v", err)
+	}
+	if diff := cmp.Diff([]byte("an encrypted backup"), got); diff != "" {
+		t.Errorf("replicated contents mismatch (-want +got):\n%s", diff)
+	}
+
+	result, err := r.Replicate(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error replicating again: %v", err)
+	}
+	if len(result.Copied) != 0 || len(result.Skipped) != 1 {
+		t.Errorf("expected the replica to be skipped, got: %+v", result)
+	}
+}
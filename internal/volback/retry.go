@@ -0,0 +1,184 @@
+package volback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/jacobmiller22/volume-backup/backend"
+	"github.com/jacobmiller22/volume-backup/internal/config"
+)
+
+// Retry backoff defaults used when a location sets retries but no backoff
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = time.Minute
+)
+
+// RetryPolicy is a location's retry policy, the same one its backend is given
+type RetryPolicy = backend.Retry
+
+// retryPolicyFromConfig reads the retry policy of loc, which has been
+// validated
+func retryPolicyFromConfig(loc *config.Location) RetryPolicy {
+	policy := RetryPolicy{
+		Retries:    loc.Retries,
+		Backoff:    defaultRetryBackoff,
+		MaxBackoff: defaultRetryMaxBackoff,
+	}
+	if loc.RetryBackoff != "" {
+		policy.Backoff, _ = time.ParseDuration(loc.RetryBackoff)
+	}
+	if loc.RetryMaxBackoff != "" {
+		policy.MaxBackoff, _ = time.ParseDuration(loc.RetryMaxBackoff)
+	}
+	if loc.RequestTimeout != "" {
+		policy.Timeout, _ = time.ParseDuration(loc.RequestTimeout)
+	}
+	return policy
+}
+
+// retryBackoff returns how long p waits before the given retry, counting
+// from 1
+func retryBackoff(p RetryPolicy, retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, p.MaxBackoff)
+	}
+	return d
+}
+
+// retryable reports whether an operation that failed with err is worth
+// trying again
+func retryable(ctx context.Context, err error) bool {
+	// The run itself was cancelled or timed out
+	if ctx.Err() != nil {
+		return false
+	}
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// retryDo calls fn until it succeeds, fails with an error that isn't worth
+// retrying, or p's retries run out. Every retry is logged with what as the
+// operation being retried.
+func retryDo(ctx context.Context, p RetryPolicy, what string, fn func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := retryAttempt(ctx, p, fn)
+		if err == nil || attempt >= p.Retries || !retryable(ctx, err) {
+			return err
+		}
+
+		wait := retryBackoff(p, attempt+1)
+		log.Printf("Retrying %s in %s, attempt %d of %d failed: %v\n", what, wait, attempt+1, p.Retries+1, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+	}
+}
+
+// retryAttempt calls fn once, cancelling it if it doesn't return within p's
+// timeout. The context passed to fn stays valid once it returns, so a reader
+// it returns can still be read.
+func retryAttempt(ctx context.Context, p RetryPolicy, fn func(ctx context.Context) error) error {
+	if p.Timeout <= 0 {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(p.Timeout, func() {
+		cancel(fmt.Errorf("timed out after %s", p.Timeout))
+	})
+
+	err := fn(ctx)
+	if timer.Stop() {
+		if err != nil {
+			cancel(err)
+		}
+		return err
+	}
+	// The timer fired, so report it rather than whatever fn failed with
+	// once it was cancelled
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", context.Cause(ctx), err)
+}
+
+// retryPull pulls path from puller, retrying the pull until it starts
+func retryPull(ctx context.Context, p RetryPolicy, puller Puller, path string) (io.Reader, error) {
+	var r io.Reader
+	err := retryDo(ctx, p, "pull of "+path, func(ctx context.Context) error {
+		var err error
+		r, err = puller.Pull(ctx, path)
+		return err
+	})
+	return r, err
+}
+
+// retryPush pushes r to path, along with metadata if pusher can store it. The
+// whole push is only retried if r can be rewound to where it started, which
+// the pipe a backup is streamed through can't be. S3 and chunked WebDAV
+// uploads retry their parts themselves; other backends get no retries then.
+func retryPush(ctx context.Context, p RetryPolicy, pusher Pusher, r io.Reader, path string, metadata map[string]string) error {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		return push(ctx, pusher, r, path, metadata)
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return push(ctx, pusher, r, path, metadata)
+	}
+
+	// Pushes can stream for hours, so they have no per-request timeout. Only
+	// S3 bounds the requests a push makes, with a response header timeout.
+	p.Timeout = 0
+	first := true
+	return retryDo(ctx, p, "push to "+path, func(ctx context.Context) error {
+		if !first {
+			if _, err := rs.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		first = false
+		return push(ctx, pusher, rs, path, metadata)
+	})
+}
+
+// retryStat describes the object at path, or returns nil if b isn't a
+// Stater
+func retryStat(ctx context.Context, p RetryPolicy, b any, path string) (*ObjectInfo, error) {
+	s, ok := b.(Stater)
+	if !ok {
+		return nil, nil
+	}
+	var info *ObjectInfo
+	err := retryDo(ctx, p, "stat of "+path, func(ctx context.Context) error {
+		var err error
+		info, err = s.Stat(ctx, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// retryList lists the objects below prefix
+func retryList(ctx context.Context, p RetryPolicy, lister Lister, prefix string) ([]string, error) {
+	var paths []string
+	err := retryDo(ctx, p, "list of "+prefix, func(ctx context.Context) error {
+		var err error
+		paths, err = lister.List(ctx, prefix)
+		return err
+	})
+	return paths, err
+}
@@ -0,0 +1,215 @@
+package volback
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jacobmiller22/volume-backup/internal/config"
+)
+
+func TestRetryPolicy_Do(t *testing.T) {
+	testCases := []struct {
+		name         string
+		failures     int
+		err          error
+		wantErr      bool
+		wantAttempts int
+	}{
+		{name: "first attempt", failures: 0, err: ErrInjected, wantAttempts: 1},
+		{name: "recovers", failures: 2, err: ErrInjected, wantAttempts: 3},
+		{name: "retries run out", failures: 5, err: ErrInjected, wantErr: true, wantAttempts: 4},
+		{name: "not exist isn't retried", failures: 5, err: fs.ErrNotExist, wantErr: true, wantAttempts: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := RetryPolicy{Retries: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+			attempts := 0
+			err := retryDo(t.Context(), policy, "test", func(context.Context) error {
+				attempts++
+				if attempts <= tc.failures {
+					return tc.err
+				}
+				return nil
+			})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if attempts != tc.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tc.wantAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Timeout(t *testing.T) {
+	policy := RetryPolicy{Retries: 1, Backoff: time.Millisecond, Timeout: 10 * time.Millisecond}
+
+	attempts := 0
+	err := retryDo(t.Context(), policy, "test", func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			// Hang until the attempt times out
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the attempt after the timeout to succeed, got: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestRetryPolicy_Cancel(t *testing.T) {
+	policy := RetryPolicy{Retries: 3, Backoff: time.Hour}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	attempts := 0
+	err := retryDo(ctx, policy, "test", func(context.Context) error {
+		attempts++
+		cancel()
+		return ErrInjected
+	})
+	if !errors.Is(err, ErrInjected) {
+		t.Errorf("expected the attempt's error, got: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected no retries once cancelled, got %d attempts", attempts)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	var got []time.Duration
+	for retry := 1; retry <= 5; retry++ {
+		got = append(got, retryBackoff(policy, retry))
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("backoff mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// flakyPusher reads part of every push and fails it until failures run out
+type flakyPusher struct {
+	mem      *MemPushPuller
+	failures int
+	pushes   int
+}
+
+func (p *flakyPusher) Push(ctx context.Context, r io.Reader, path string) error {
+	p.pushes++
+	if p.pushes <= p.failures {
+		io.CopyN(io.Discard, r, 4)
+		return fmt.Errorf("push %d: %w", p.pushes, ErrInjected)
+	}
+	return p.mem.Push(ctx, r, path)
+}
+
+func TestRetryPolicy_Push(t *testing.T) {
+	policy := RetryPolicy{Retries: 2, Backoff: time.Millisecond}
+	given := []byte("contents that are partly read by failed pushes")
+
+	t.Run("rewinds a seekable source", func(t *testing.T) {
+		p := &flakyPusher{mem: NewMemPushPuller(), failures: 2}
+		if err := retryPush(t.Context(), policy, p, bytes.NewReader(given), "backup", nil); err != nil {
+			t.Fatalf("unexpected error pushing: %v", err)
+		}
+		got, _ := p.mem.Get("backup")
+		if diff := cmp.Diff(given, got); diff != "" {
+			t.Errorf("pushed contents mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("doesn't retry a stream", func(t *testing.T) {
+		p := &flakyPusher{mem: NewMemPushPuller(), failures: 2}
+		r := io.MultiReader(bytes.NewReader(given))
+		if err := retryPush(t.Context(), policy, p, r, "backup", nil); !errors.Is(err, ErrInjected) {
+			t.Fatalf("expected injected error, got: %v", err)
+		}
+		if p.pushes != 1 {
+			t.Errorf("expected a single push, got %d", p.pushes)
+		}
+	})
+}
+
+// flakyPuller fails the first failures pulls
+type flakyPuller struct {
+	mem      *MemPushPuller
+	failures int
+	pulls    int
+}
+
+func (p *flakyPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	p.pulls++
+	if p.pulls <= p.failures {
+		return nil, ErrInjected
+	}
+	return p.mem.Pull(ctx, path)
+}
+
+func TestExecutor_RetryPull(t *testing.T) {
+	given := []byte("contents pulled on the second attempt")
+	src := &flakyPuller{mem: NewMemPushPuller(), failures: 1}
+	src.mem.Put("source", given)
+
+	cfg := newTestConfig()
+	cfg.Source.RetryLocation = config.RetryLocation{Retries: 1, RetryBackoff: "1ms"}
+	dst := NewMemPushPuller()
+	e, err := NewExecutor(cfg, src, dst)
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	if _, err := e.Backup(t.Context()); err != nil {
+		t.Fatalf("unexpected error backing up: %v", err)
+	}
+	if src.pulls != 2 {
+		t.Errorf("expected 2 pulls, got %d", src.pulls)
+	}
+	if _, ok := dst.Get("backup"); !ok {
+		t.Errorf("expected the backup to be pushed")
+	}
+}
+
+func TestRetryLocation_Validate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		retry   config.RetryLocation
+		wantErr string
+	}{
+		{name: "valid", retry: config.RetryLocation{RequestTimeout: "2m", Retries: 3, RetryBackoff: "1s", RetryMaxBackoff: "1m"}},
+		{name: "negative retries", retry: config.RetryLocation{Retries: -1}, wantErr: "retries can't be negative"},
+		{name: "invalid backoff", retry: config.RetryLocation{RetryBackoff: "soon"}, wantErr: "invalid retry_backoff"},
+		{name: "invalid timeout", retry: config.RetryLocation{RequestTimeout: "2"}, wantErr: "invalid request_timeout"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.Destination.RetryLocation = tc.retry
+
+			err := cfg.Validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
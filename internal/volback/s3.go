@@ -2,71 +2,810 @@ package volback
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/jacobmiller22/volume-backup/internal/config"
+	"github.com/jacobmiller22/volume-backup/backend"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	awscredentials "github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
-func newAwsCfg(loc *config.Location) (*aws.Config, error) {
+func init() {
+	backend.Register("s3", backend.Backend{
+		NewPuller: func(ctx context.Context, loc *backend.Location) (Puller, error) {
+			return newS3PushPullerFromLocation(ctx, loc)
+		},
+		NewPusher: func(ctx context.Context, loc *backend.Location) (Pusher, error) {
+			p, err := newS3PushPullerFromLocation(ctx, loc)
+			if err != nil {
+				return nil, err
+			}
+			if p.lockMode != "" || p.legalHold {
+				if err := p.checkObjectLock(ctx); err != nil {
+					return nil, err
+				}
+			}
+			return p, nil
+		},
+		Options: &S3Options{},
+		Validate: func(loc *backend.Location) error {
+			opts, err := decodeOptions[S3Options](loc)
+			if err != nil {
+				return err
+			}
+			if err := validateS3Credentials(opts); err != nil {
+				return err
+			}
+			return validateS3Objects(opts)
+		},
+	})
+}
+
+// S3Options configure an s3 location
+type S3Options struct {
+	S3_AccessKeyId     string `json:"s3_access_key_id" usage:"The access key id"`
+	S3_SecretAccessKey string `json:"s3_secret_access_key" usage:"The secret access key"`
+	S3_Endpoint        string `json:"s3_endpoint" usage:"Hostname to use as an endpoint for s3 compatible storage"`
+	S3_Bucket          string `json:"s3_bucket" usage:"Name of the bucket"`
+	S3_Region          string `json:"s3_region" usage:"Region of the bucket"`
+	S3_SessionToken    string `json:"s3_session_token" usage:"Session token for temporary static credentials"`
+	// S3_ForcePathStyle addresses buckets in the url path rather than the
+	// hostname, as most s3 compatible servers need. It defaults to the
+	// S3_FORCE_PATH_STYLE environment variable
+	S3_ForcePathStyle bool `json:"s3_force_path_style" usage:"Address buckets in the url path instead of the hostname"`
+
+	// S3_Credentials is one of "static", "default" for the SDK's default
+	// chain, "profile", "assume-role" or "web-identity". Defaults to static
+	// if an access key is set, otherwise to the default chain
+	S3_Credentials string `json:"s3_credentials" usage:"Where credentials come from: static, default, profile, assume-role or web-identity"`
+	S3_Profile     string `json:"s3_profile" usage:"Shared config profile to use with profile credentials"`
+	// The role is assumed with the static keys if they are set, otherwise
+	// with the default chain
+	S3_RoleArn              string `json:"s3_role_arn" usage:"ARN of the role to assume"`
+	S3_ExternalId           string `json:"s3_external_id" usage:"External ID to pass when assuming the role"`
+	S3_RoleSessionName      string `json:"s3_role_session_name" usage:"Session name to use when assuming the role"`
+	S3_WebIdentityTokenFile string `json:"s3_web_identity_token_file" usage:"Path to the OIDC token to assume the role with"`
+
+	S3_StorageClass string `json:"s3_storage_class" scope:"dst" usage:"Storage class to upload with, e.g. STANDARD_IA, GLACIER_IR or DEEP_ARCHIVE"`
+	// S3_ServerSideEncryption is one of "sse-s3", "sse-kms" or "sse-c".
+	// S3_SseCustomerKey is needed to read sse-c objects back too
+	S3_ServerSideEncryption string `json:"s3_server_side_encryption" flag:"s3-sse" usage:"Server-side encryption: sse-s3, sse-kms or sse-c"`
+	S3_SseKmsKeyId          string `json:"s3_sse_kms_key_id" scope:"dst" usage:"KMS key to encrypt with, defaults to the AWS managed key"`
+	S3_SseCustomerKey       string `json:"s3_sse_customer_key" usage:"Base64 encoded 256-bit key for sse-c"`
+
+	// Tags and metadata are set on every uploaded object, alongside the
+	// metadata volback records about the backup
+	S3_Tags     map[string]string `json:"s3_tags" flag:"s3-tag" scope:"dst" usage:"Tag to set on uploaded objects as \"key=value\", may be repeated"`
+	S3_Metadata map[string]string `json:"s3_metadata" scope:"dst" usage:"User metadata to set on uploaded objects as \"key=value\", may be repeated"`
+
+	// Object Lock protects uploaded objects from being deleted or overwritten
+	// until S3_ObjectLockRetention, e.g. "720h" or "30d", has passed. The mode
+	// is either "GOVERNANCE" or "COMPLIANCE". The bucket must have Object Lock
+	// enabled.
+	S3_ObjectLockMode      string `json:"s3_object_lock_mode" scope:"dst" usage:"Object Lock retention mode: GOVERNANCE or COMPLIANCE"`
+	S3_ObjectLockRetention string `json:"s3_object_lock_retention" scope:"dst" usage:"How long uploaded objects are locked for, e.g. 720h or 30d"`
+	S3_ObjectLockLegalHold bool   `json:"s3_object_lock_legal_hold" scope:"dst" usage:"Place a legal hold on uploaded objects"`
+
+	// Uploads are split into parts of S3_PartSize bytes, S3_Concurrency of
+	// which are uploaded at once. If S3_PartSize isn't set it is picked so an
+	// upload of S3_ExpectedSize bytes fits in S3's 10,000 part limit.
+	S3_PartSize     int `json:"s3_part_size" scope:"dst" usage:"Size in bytes of each multipart upload part, at least 5 MiB"`
+	S3_Concurrency  int `json:"s3_concurrency" scope:"dst" usage:"Number of parts to upload concurrently"`
+	S3_ExpectedSize int `json:"s3_expected_size" scope:"dst" usage:"Expected size in bytes of the largest upload, used to pick a part size when none is set"`
+
+	// Objects are downloaded as ranges of S3_DownloadPartSize bytes,
+	// S3_DownloadConcurrency of which are downloaded at once
+	S3_DownloadPartSize    int `json:"s3_download_part_size" scope:"src" usage:"Size in bytes of each ranged download"`
+	S3_DownloadConcurrency int `json:"s3_download_concurrency" scope:"src" usage:"Number of ranges to download concurrently, 1 downloads a single stream"`
+
+	// S3_Checksum is "sha256" or "crc32c". Uploads are checksummed as they
+	// are streamed. Downloads are verified against whichever checksum was
+	// recorded, setting it requires that one was recorded with this algorithm
+	S3_Checksum string `json:"s3_checksum" usage:"Checksum objects end to end with sha256 or crc32c"`
+	// S3_AllowUnverified restores objects that have no recorded checksum
+	// without verifying them, instead of failing
+	S3_AllowUnverified bool `json:"s3_allow_unverified" scope:"src" usage:"Restore objects that have no recorded checksum without verifying them"`
+}
+
+func newS3PushPullerFromLocation(ctx context.Context, loc *backend.Location) (*S3PushPuller, error) {
+	opts, err := decodeOptions[S3Options](loc)
+	if err != nil {
+		return nil, err
+	}
+	return newS3PushPuller(ctx, opts, loc.Retry)
+}
+
+// s3Credentials returns where the credentials come from, see
+// S3Options.S3_Credentials
+func s3Credentials(opts *S3Options) string {
+	if opts.S3_Credentials != "" {
+		return opts.S3_Credentials
+	}
+	if opts.S3_AccessKeyId != "" {
+		return "static"
+	}
+	return "default"
+}
+
+func validateS3Credentials(opts *S3Options) error {
+	hasKeys := opts.S3_AccessKeyId != "" || opts.S3_SecretAccessKey != ""
+	switch mode := s3Credentials(opts); mode {
+	case "static":
+		if opts.S3_AccessKeyId == "" || opts.S3_SecretAccessKey == "" {
+			return fmt.Errorf("s3_access_key_id and s3_secret_access_key are required for static credentials")
+		}
+	case "default", "profile", "web-identity":
+		if hasKeys {
+			return fmt.Errorf("s3_access_key_id and s3_secret_access_key can't be used with %s credentials", mode)
+		}
+		if mode == "profile" && opts.S3_Profile == "" {
+			return fmt.Errorf("s3_profile is required for profile credentials")
+		}
+		if mode == "web-identity" && (opts.S3_RoleArn == "" || opts.S3_WebIdentityTokenFile == "") {
+			return fmt.Errorf("s3_role_arn and s3_web_identity_token_file are required for web-identity credentials")
+		}
+	case "assume-role":
+		if opts.S3_RoleArn == "" {
+			return fmt.Errorf("s3_role_arn is required for assume-role credentials")
+		}
+		if (opts.S3_AccessKeyId == "") != (opts.S3_SecretAccessKey == "") {
+			return fmt.Errorf("s3_access_key_id and s3_secret_access_key must be set together")
+		}
+	default:
+		return fmt.Errorf("invalid s3_credentials %q, must be one of static, default, profile, assume-role or web-identity", mode)
+	}
+	return nil
+}
 
-	awsCfg, err := awsconfig.LoadDefaultConfig(
-		context.Background(),
-		awsconfig.WithCredentialsProvider(
+// validateS3Objects checks the options uploaded objects are created with
+func validateS3Objects(opts *S3Options) error {
+	if opts.S3_StorageClass != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(opts.S3_StorageClass)) {
+		return fmt.Errorf("invalid s3_storage_class %q", opts.S3_StorageClass)
+	}
+	switch opts.S3_ServerSideEncryption {
+	case "", "sse-s3", "sse-kms":
+		if opts.S3_SseCustomerKey != "" {
+			return fmt.Errorf("s3_sse_customer_key can only be used with sse-c")
+		}
+	case "sse-c":
+		key, err := base64.StdEncoding.DecodeString(opts.S3_SseCustomerKey)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("s3_sse_customer_key must be a base64 encoded 256-bit key for sse-c")
+		}
+	default:
+		return fmt.Errorf("invalid s3_server_side_encryption %q, must be one of sse-s3, sse-kms or sse-c", opts.S3_ServerSideEncryption)
+	}
+	if opts.S3_SseKmsKeyId != "" && opts.S3_ServerSideEncryption != "sse-kms" {
+		return fmt.Errorf("s3_sse_kms_key_id can only be used with sse-kms")
+	}
+	if (opts.S3_ObjectLockMode == "") != (opts.S3_ObjectLockRetention == "") {
+		return fmt.Errorf("s3_object_lock_mode and s3_object_lock_retention must be set together")
+	}
+	if opts.S3_ObjectLockMode != "" && !slices.Contains(types.ObjectLockMode("").Values(), types.ObjectLockMode(opts.S3_ObjectLockMode)) {
+		return fmt.Errorf("invalid s3_object_lock_mode %q, must be one of GOVERNANCE or COMPLIANCE", opts.S3_ObjectLockMode)
+	}
+	if opts.S3_ObjectLockRetention != "" {
+		if _, err := parseRetention(opts.S3_ObjectLockRetention); err != nil {
+			return fmt.Errorf("invalid s3_object_lock_retention: %w", err)
+		}
+	}
+	if opts.S3_PartSize != 0 && int64(opts.S3_PartSize) < s3manager.MinUploadPartSize {
+		return fmt.Errorf("s3_part_size must be at least %d bytes", s3manager.MinUploadPartSize)
+	}
+	if opts.S3_Concurrency < 0 || opts.S3_ExpectedSize < 0 {
+		return fmt.Errorf("s3_concurrency and s3_expected_size can't be negative")
+	}
+	if opts.S3_DownloadPartSize < 0 || opts.S3_DownloadConcurrency < 0 {
+		return fmt.Errorf("s3_download_part_size and s3_download_concurrency can't be negative")
+	}
+	if int64(opts.S3_ExpectedSize) > s3MaxObjectSize {
+		return fmt.Errorf("s3_expected_size is larger than the largest S3 object")
+	}
+	if opts.S3_PartSize != 0 && int64(opts.S3_ExpectedSize) > int64(opts.S3_PartSize)*int64(s3manager.MaxUploadParts) {
+		return fmt.Errorf("s3_part_size is too small to upload s3_expected_size bytes in %d parts", s3manager.MaxUploadParts)
+	}
+	switch opts.S3_Checksum {
+	case "", "sha256", "crc32c":
+	default:
+		return fmt.Errorf("invalid s3_checksum %q, must be one of sha256 or crc32c", opts.S3_Checksum)
+	}
+	if len(opts.S3_Tags) > s3MaxTags-2 {
+		// Tags are kept for the object's size and checksum
+		return fmt.Errorf("at most %d s3_tags can be set", s3MaxTags-2)
+	}
+	return nil
+}
+
+// s3MaxObjectSize is the largest object S3 can store
+const s3MaxObjectSize = 5 << 40
+
+// s3PartSize returns partSize if it is set, otherwise the smallest whole
+// number of MiB that fits expectedSize in S3's part limit. It returns 0 to
+// use the uploader's default.
+func s3PartSize(partSize, expectedSize int64) int64 {
+	if partSize > 0 || expectedSize == 0 {
+		return partSize
+	}
+	const mib = 1 << 20
+	size := (expectedSize + int64(s3manager.MaxUploadParts) - 1) / int64(s3manager.MaxUploadParts)
+	size = (size + mib - 1) / mib * mib
+	return max(size, s3manager.MinUploadPartSize)
+}
+
+// parseRetention parses a positive duration, which may also be a whole
+// number of days such as "30d"
+func parseRetention(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%q must be positive", s)
+	}
+	return d, nil
+}
+
+func newAwsCfg(ctx context.Context, opts *S3Options, retry RetryPolicy) (*aws.Config, error) {
+
+	loadOpts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithBaseEndpoint(opts.S3_Endpoint),
+		awsconfig.WithRegion(opts.S3_Region),
+	}
+
+	mode := s3Credentials(opts)
+	switch {
+	case mode == "profile":
+		loadOpts = append(loadOpts, awsconfig.WithSharedConfigProfile(opts.S3_Profile))
+	case opts.S3_AccessKeyId != "":
+		// Either static credentials, or the ones a role is assumed with
+		loadOpts = append(loadOpts, awsconfig.WithCredentialsProvider(
 			awscredentials.NewStaticCredentialsProvider(
-				loc.S3_AccessKeyId,
-				loc.S3_SecretAccessKey,
-				"",
+				opts.S3_AccessKeyId,
+				opts.S3_SecretAccessKey,
+				opts.S3_SessionToken,
 			),
-		),
-		awsconfig.WithBaseEndpoint(loc.S3_Endpoint),
-		awsconfig.WithRegion(loc.S3_Region),
-	)
+		))
+	}
 
+	if retry.Retries > 0 || retry.Timeout > 0 {
+		loadOpts = append(loadOpts, s3RetryOptions(retry)...)
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("error loading default aws config: %w", err)
 	}
 
+	switch mode {
+	case "assume-role", "web-identity":
+		// The endpoint is the bucket's, not STS's
+		stsClient := sts.NewFromConfig(awsCfg, func(o *sts.Options) { o.BaseEndpoint = nil })
+
+		var provider aws.CredentialsProvider
+		if mode == "assume-role" {
+			provider = stscreds.NewAssumeRoleProvider(stsClient, opts.S3_RoleArn, func(o *stscreds.AssumeRoleOptions) {
+				if opts.S3_ExternalId != "" {
+					o.ExternalID = aws.String(opts.S3_ExternalId)
+				}
+				if opts.S3_RoleSessionName != "" {
+					o.RoleSessionName = opts.S3_RoleSessionName
+				}
+			})
+		} else {
+			provider = stscreds.NewWebIdentityRoleProvider(stsClient, opts.S3_RoleArn, stscreds.IdentityTokenFile(opts.S3_WebIdentityTokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = opts.S3_RoleSessionName
+			})
+		}
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
 	return &awsCfg, nil
 }
 
+// s3RetryOptions make the SDK retry failed requests, including each part of
+// a multipart upload, as the policy does. Streaming a request's body isn't
+// bounded by the policy's timeout, only waiting for the response to it.
+func s3RetryOptions(policy RetryPolicy) []func(*awsconfig.LoadOptions) error {
+	var opts []func(*awsconfig.LoadOptions) error
+	if policy.Retries > 0 {
+		opts = append(opts,
+			awsconfig.WithRetryer(func() aws.Retryer {
+				return retry.NewStandard(func(o *retry.StandardOptions) {
+					o.MaxAttempts = policy.Retries + 1
+					o.MaxBackoff = policy.MaxBackoff
+					o.Backoff = retry.BackoffDelayerFunc(func(attempt int, _ error) (time.Duration, error) {
+						return retryBackoff(policy, attempt), nil
+					})
+				})
+			}),
+			awsconfig.WithClientLogMode(aws.LogRetries),
+		)
+	}
+	if policy.Timeout > 0 {
+		opts = append(opts, awsconfig.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			tr.ResponseHeaderTimeout = policy.Timeout
+		})))
+	}
+	return opts
+}
+
+func newS3PushPuller(ctx context.Context, opts *S3Options, retry RetryPolicy) (*S3PushPuller, error) {
+	awsCfg, err := newAwsCfg(ctx, opts, retry)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &S3PushPuller{
+		s3client:     s3.NewFromConfig(*awsCfg, func(o *s3.Options) { o.UsePathStyle = opts.S3_ForcePathStyle }),
+		bucket:       opts.S3_Bucket,
+		storageClass: types.StorageClass(opts.S3_StorageClass),
+		tags:         opts.S3_Tags,
+		metadata:     opts.S3_Metadata,
+		lockMode:     types.ObjectLockMode(opts.S3_ObjectLockMode),
+		legalHold:    opts.S3_ObjectLockLegalHold,
+		partSize:     s3PartSize(int64(opts.S3_PartSize), int64(opts.S3_ExpectedSize)),
+		concurrency:  opts.S3_Concurrency,
+
+		downloadPartSize:    int64(opts.S3_DownloadPartSize),
+		downloadConcurrency: opts.S3_DownloadConcurrency,
+
+		checksum:        opts.S3_Checksum,
+		allowUnverified: opts.S3_AllowUnverified,
+	}
+	if p.downloadPartSize == 0 {
+		p.downloadPartSize = s3manager.DefaultDownloadPartSize
+	}
+	if p.downloadConcurrency == 0 {
+		p.downloadConcurrency = s3manager.DefaultDownloadConcurrency
+	}
+
+	if opts.S3_ObjectLockRetention != "" {
+		if p.lockRetention, err = parseRetention(opts.S3_ObjectLockRetention); err != nil {
+			return nil, fmt.Errorf("invalid s3_object_lock_retention: %w", err)
+		}
+	}
+
+	switch opts.S3_ServerSideEncryption {
+	case "sse-s3":
+		p.sse = types.ServerSideEncryptionAes256
+	case "sse-kms":
+		p.sse = types.ServerSideEncryptionAwsKms
+		p.kmsKeyId = opts.S3_SseKmsKeyId
+	case "sse-c":
+		key, err := base64.StdEncoding.DecodeString(opts.S3_SseCustomerKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3_sse_customer_key: %w", err)
+		}
+		sum := md5.Sum(key)
+		p.customerKey = opts.S3_SseCustomerKey
+		p.customerKeyMD5 = base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	return p, nil
+}
+
+// SizeTagKey is the tag an uploaded object's size is recorded under. S3
+// metadata is sent before the object is streamed, so it can't hold the size.
+const SizeTagKey = "volback-size"
+
+// s3MaxTags is the most tags S3 allows on an object
+const s3MaxTags = 10
+
+// s3AbortTimeout bounds aborting a failed upload, which happens after the
+// push's own context may have been cancelled
+const s3AbortTimeout = 30 * time.Second
+
 type S3PushPuller struct {
 	s3client *s3.Client
 	bucket   string
+
+	storageClass types.StorageClass
+	sse          types.ServerSideEncryption
+	kmsKeyId     string
+	// The base64 encoded key and its MD5 for sse-c, which every request for
+	// the object must send
+	customerKey    string
+	customerKeyMD5 string
+
+	tags     map[string]string
+	metadata map[string]string
+
+	// Objects are locked until lockRetention after they are pushed
+	lockMode      types.ObjectLockMode
+	lockRetention time.Duration
+	legalHold     bool
+
+	// Either is 0 to use the uploader's default
+	partSize    int64
+	concurrency int
+
+	downloadPartSize    int64
+	downloadConcurrency int
+
+	// checksum is the algorithm objects are checksummed with, if any
+	checksum string
+	// allowUnverified pulls objects without a recorded checksum unverified
+	allowUnverified bool
 }
 
-func (p *S3PushPuller) Pull(path string) (io.Reader, error) {
+// s3ChecksumAlgorithms are the checksums pushes can record, in the order a
+// pull without a configured algorithm looks for them
+var s3ChecksumAlgorithms = []string{"sha256", "crc32c"}
 
-	input := &s3.GetObjectInput{
-		Bucket: &p.bucket,
-		Key:    &path,
+// checksumTagKey is the tag a checksum with the given algorithm is recorded
+// under. A sha256 is recorded under ChecksumMetadataKey, so replicate and pull
+// trust the same value.
+func checksumTagKey(algorithm string) string {
+	if algorithm == "sha256" {
+		return ChecksumMetadataKey
+	}
+	return "volback-" + algorithm
+}
+
+// newChecksumHash returns a hash for the given checksum algorithm
+func newChecksumHash(algorithm string) hash.Hash {
+	if algorithm == "crc32c" {
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
 	}
-	output, err := p.s3client.GetObject(context.Background(), input)
+	return sha256.New()
+}
+
+// checksumAlgorithm is the S3 additional checksum that parts are uploaded
+// with, so S3 rejects any that are corrupted in transit
+func (p *S3PushPuller) checksumAlgorithm() types.ChecksumAlgorithm {
+	switch p.checksum {
+	case "sha256":
+		return types.ChecksumAlgorithmSha256
+	case "crc32c":
+		return types.ChecksumAlgorithmCrc32c
+	}
+	return ""
+}
+
+func (p *S3PushPuller) newUploader() *s3manager.Uploader {
+	return s3manager.NewUploader(p.s3client, func(u *s3manager.Uploader) {
+		if p.partSize > 0 {
+			u.PartSize = p.partSize
+		}
+		if p.concurrency > 0 {
+			u.Concurrency = p.concurrency
+		}
+		// A failed multipart upload is aborted by abortUpload, which unlike
+		// the uploader can still do so once the push has been cancelled
+		u.LeavePartsOnError = true
+	})
+}
+
+// checkObjectLock fails unless the bucket has Object Lock enabled, so pushes
+// don't silently go unprotected
+func (p *S3PushPuller) checkObjectLock(ctx context.Context) error {
+	output, err := p.s3client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: &p.bucket,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get object from S3, %w", err)
+		return fmt.Errorf("failed to get object lock configuration of bucket %s, %w", p.bucket, err)
+	}
+	if output.ObjectLockConfiguration == nil || output.ObjectLockConfiguration.ObjectLockEnabled != types.ObjectLockEnabledEnabled {
+		return fmt.Errorf("bucket %s doesn't have object lock enabled", p.bucket)
 	}
+	return nil
+}
 
-	return output.Body, nil
+// sseCustomerAlgorithm is sent along with the key for sse-c
+func (p *S3PushPuller) sseCustomerAlgorithm() *string {
+	if p.customerKey == "" {
+		return nil
+	}
+	return aws.String("AES256")
+}
 
+func (p *S3PushPuller) Push(ctx context.Context, r io.Reader, path string) error {
+	return p.PushWithMetadata(ctx, r, path, nil)
 }
 
-func (p *S3PushPuller) Push(r io.Reader, path string) error {
+// PushWithMetadata stores metadata as user-defined object metadata, along
+// with the configured metadata and tags. The object's size and checksum are
+// tagged once it has been uploaded. By then it has replaced any object
+// already at path, so if tagging the checksum fails the push fails, but
+// leaves an object behind that pulls refuse to restore unverified.
+func (p *S3PushPuller) PushWithMetadata(ctx context.Context, r io.Reader, path string, metadata map[string]string) error {
+
+	uploader := p.newUploader()
 
-	uploader := s3manager.NewUploader(p.s3client)
+	// Metadata describing the backup wins over configured metadata
+	objMetadata := maps.Clone(p.metadata)
+	if objMetadata == nil {
+		objMetadata = map[string]string{}
+	}
+	maps.Copy(objMetadata, metadata)
+
+	counter := &countingReader{r: r}
+	body := io.Reader(counter)
+	var h hash.Hash
+	if p.checksum != "" {
+		h = newChecksumHash(p.checksum)
+		body = io.TeeReader(counter, h)
+	}
 
 	upParams := s3.PutObjectInput{
-		Bucket: aws.String(p.bucket),
-		Key:    aws.String(path),
-		Body:   r,
+		Bucket:               aws.String(p.bucket),
+		Key:                  aws.String(path),
+		Body:                 body,
+		ChecksumAlgorithm:    p.checksumAlgorithm(),
+		Metadata:             objMetadata,
+		StorageClass:         p.storageClass,
+		ServerSideEncryption: p.sse,
+		SSEKMSKeyId:          optionalString(p.kmsKeyId),
+		SSECustomerAlgorithm: p.sseCustomerAlgorithm(),
+		SSECustomerKey:       optionalString(p.customerKey),
+		SSECustomerKeyMD5:    optionalString(p.customerKeyMD5),
+		Tagging:              optionalString(encodeTags(p.tags)),
+	}
+	if p.lockMode != "" {
+		upParams.ObjectLockMode = p.lockMode
+		upParams.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(p.lockRetention))
+	}
+	if p.legalHold {
+		upParams.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+
+	if _, err := uploader.Upload(ctx, &upParams); err != nil {
+		p.abortUpload(ctx, path, err)
+		return err
+	}
+
+	// S3 metadata is sent before the object is streamed, so the size and
+	// checksum are recorded as tags instead
+	tags := maps.Clone(p.tags)
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	tags[SizeTagKey] = strconv.FormatInt(counter.n, 10)
+	if h != nil {
+		tags[checksumTagKey(p.checksum)] = hex.EncodeToString(h.Sum(nil))
+	}
+	tagSet := make([]types.Tag, 0, len(tags))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	_, err := p.s3client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  &p.bucket,
+		Key:     &path,
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	if err != nil {
+		// Without its checksum the backup can't be verified, otherwise it is
+		// intact and failing to tag it is only logged
+		if h != nil {
+			return fmt.Errorf("failed to tag object in S3 with its checksum, %w", err)
+		}
+		log.Printf("Failed to tag %s with its size: %v\n", path, err)
+	}
+
+	return nil
+}
+
+// abortUpload aborts the multipart upload that failed with err, so its parts
+// aren't left behind to be paid for. It is aborted even if ctx was cancelled.
+func (p *S3PushPuller) abortUpload(ctx context.Context, path string, err error) {
+	var failure s3manager.MultiUploadFailure
+	if !errors.As(err, &failure) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s3AbortTimeout)
+	defer cancel()
+	_, aerr := p.s3client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &p.bucket,
+		Key:      &path,
+		UploadId: aws.String(failure.UploadID()),
+	})
+	if aerr != nil {
+		log.Printf("Failed to abort upload of %s, its parts remain until abort-uploads is run: %v\n", path, aerr)
+	}
+}
+
+// expectedChecksum returns the algorithm and checksum recorded when the
+// object at path was pushed, or "" if it has none. Only the configured
+// algorithm is looked for if there is one. metadata is the object's user
+// metadata, where replicate records a sha256.
+func (p *S3PushPuller) expectedChecksum(ctx context.Context, path string, metadata map[string]string) (string, string, error) {
+	algorithms := s3ChecksumAlgorithms
+	if p.checksum != "" {
+		algorithms = []string{p.checksum}
+	}
+	for _, algorithm := range algorithms {
+		if sum := metadata[checksumTagKey(algorithm)]; sum != "" {
+			return algorithm, sum, nil
+		}
 	}
 
-	_, err := uploader.Upload(context.Background(), &upParams)
+	tags, err := p.objectTags(ctx, path)
+	if err != nil {
+		return "", "", err
+	}
+	for _, algorithm := range algorithms {
+		if sum := tags[checksumTagKey(algorithm)]; sum != "" {
+			return algorithm, sum, nil
+		}
+	}
+	return "", "", nil
+}
+
+// checksumTag returns the value of the object's key tag, or "" if it has none
+func (p *S3PushPuller) checksumTag(ctx context.Context, path string, key string) (string, error) {
+	tags, err := p.objectTags(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	return tags[key], nil
+}
+
+// objectTags returns the tags of the object at path
+func (p *S3PushPuller) objectTags(ctx context.Context, path string) (map[string]string, error) {
+	output, err := p.s3client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: &p.bucket,
+		Key:    &path,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object tags from S3, %w", err)
+	}
+	tags := make(map[string]string, len(output.TagSet))
+	for _, tag := range output.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+// encodeTags encodes tags as the query string S3 expects on upload
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// optionalString returns nil for an empty string, so it isn't sent
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// AbortStaleUploads aborts multipart uploads below prefix that were started
+// more than olderThan ago, such as those left behind by a crashed run. It
+// returns the keys of the aborted uploads.
+func (p *S3PushPuller) AbortStaleUploads(ctx context.Context, prefix string, olderThan time.Duration) ([]string, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	var aborted []string
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: &p.bucket,
+		Prefix: &prefix,
+	}
+	for {
+		page, err := p.s3client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return aborted, fmt.Errorf("failed to list multipart uploads in S3, %w", err)
+		}
+		for _, upload := range page.Uploads {
+			if upload.Initiated != nil && upload.Initiated.After(cutoff) {
+				continue
+			}
+			_, err := p.s3client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   &p.bucket,
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil {
+				return aborted, fmt.Errorf("failed to abort multipart upload of %s in S3, %w", aws.ToString(upload.Key), err)
+			}
+			aborted = append(aborted, aws.ToString(upload.Key))
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			return aborted, nil
+		}
+		input.KeyMarker, input.UploadIdMarker = page.NextKeyMarker, page.NextUploadIdMarker
+	}
+}
+
+func (p *S3PushPuller) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(p.s3client, &s3.ListObjectsV2Input{
+		Bucket: &p.bucket,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in S3, %w", err)
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+	return keys, nil
+}
+
+func (p *S3PushPuller) Stat(ctx context.Context, path string) (*ObjectInfo, error) {
+	output, err := p.s3client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               &p.bucket,
+		Key:                  &path,
+		SSECustomerAlgorithm: p.sseCustomerAlgorithm(),
+		SSECustomerKey:       optionalString(p.customerKey),
+		SSECustomerKeyMD5:    optionalString(p.customerKeyMD5),
+	})
+	if err != nil {
+		var nf *types.NotFound
+		if errors.As(err, &nf) {
+			return nil, fmt.Errorf("failed to head object in S3, %w", fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("failed to head object in S3, %w", err)
+	}
+
+	metadata := output.Metadata
+	if metadata[ChecksumMetadataKey] == "" {
+		// Pushes checksummed with sha256 record it in a tag, which counts the
+		// same as metadata. Without either the object is read to checksum it.
+		if sum, err := p.checksumTag(ctx, path, ChecksumMetadataKey); err == nil && sum != "" {
+			metadata = maps.Clone(metadata)
+			if metadata == nil {
+				metadata = map[string]string{}
+			}
+			metadata[ChecksumMetadataKey] = sum
+		}
+	}
 
-	return err
+	return &ObjectInfo{
+		Size:     aws.ToInt64(output.ContentLength),
+		Metadata: metadata,
+	}, nil
 }
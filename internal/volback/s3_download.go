@@ -0,0 +1,280 @@
+package volback
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3MaxResumes is how many times in a row a download is resumed without
+// receiving any bytes before it fails
+const s3MaxResumes = 3
+
+// getObject gets the bytes of key in rng, failing if the object no longer
+// has the given etag
+func (p *S3PushPuller) getObject(ctx context.Context, key string, etag *string, rng string) (io.ReadCloser, error) {
+	output, err := p.s3client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:               &p.bucket,
+		Key:                  &key,
+		Range:                optionalString(rng),
+		IfMatch:              etag,
+		SSECustomerAlgorithm: p.sseCustomerAlgorithm(),
+		SSECustomerKey:       optionalString(p.customerKey),
+		SSECustomerKeyMD5:    optionalString(p.customerKeyMD5),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object from S3, %w", err)
+	}
+	return output.Body, nil
+}
+
+// s3ResumingReader reads an object from offset to end inclusive, or to the
+// end of the object if end is negative. If the connection drops, the rest is
+// requested again from the last byte received.
+type s3ResumingReader struct {
+	ctx  context.Context
+	p    *S3PushPuller
+	key  string
+	etag *string
+
+	body     io.ReadCloser
+	offset   int64
+	end      int64
+	failures int
+}
+
+func (p *S3PushPuller) newResumingReader(ctx context.Context, key string, etag *string, start, end int64) (*s3ResumingReader, error) {
+	r := &s3ResumingReader{ctx: ctx, p: p, key: key, etag: etag, offset: start, end: end}
+	body, err := p.getObject(ctx, key, etag, r.rng())
+	if err != nil {
+		return nil, err
+	}
+	r.body = body
+	return r, nil
+}
+
+// rng is the range of bytes that haven't been read yet
+func (r *s3ResumingReader) rng() string {
+	if r.end < 0 {
+		if r.offset == 0 {
+			return ""
+		}
+		return fmt.Sprintf("bytes=%d-", r.offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.offset, r.end)
+}
+
+func (r *s3ResumingReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.body.Read(b)
+		r.offset += int64(n)
+		if n > 0 {
+			r.failures = 0
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if rerr := r.resume(err); rerr != nil {
+			return n, rerr
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume requests the rest of the object again after cause interrupted it
+func (r *s3ResumingReader) resume(cause error) error {
+	r.failures++
+	if r.failures > s3MaxResumes || r.ctx.Err() != nil {
+		return fmt.Errorf("failed to read object from S3, %w", cause)
+	}
+	log.Printf("Resuming download of %s from byte %d: %v\n", r.key, r.offset, cause)
+
+	r.body.Close()
+	body, err := r.p.getObject(r.ctx, r.key, r.etag, r.rng())
+	if err != nil {
+		return err
+	}
+	r.body = body
+	return nil
+}
+
+func (r *s3ResumingReader) Close() error {
+	return r.body.Close()
+}
+
+// s3RangeReader downloads an object in parts of partSize bytes, up to
+// concurrency at a time, and reads them back in order. Every part is held in
+// memory, so at most concurrency parts are downloaded ahead of the reader.
+type s3RangeReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	p      *S3PushPuller
+	key    string
+	etag   *string
+	size   int64
+
+	partSize    int64
+	concurrency int
+
+	// next is the offset of the next part to download
+	next    int64
+	pending []chan s3Part
+	buf     []byte
+	err     error
+}
+
+type s3Part struct {
+	data []byte
+	err  error
+}
+
+func (r *s3RangeReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+		if len(r.pending) == 0 {
+			r.err = io.EOF
+			continue
+		}
+
+		part := <-r.pending[0]
+		r.pending = r.pending[1:]
+		if part.err != nil {
+			// Stop downloading the parts that will never be read
+			r.err = part.err
+			r.cancel()
+			continue
+		}
+		r.buf = part.data
+	}
+
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fill starts downloading parts until concurrency of them are pending
+func (r *s3RangeReader) fill() {
+	for len(r.pending) < r.concurrency && r.next < r.size {
+		start, end := r.next, min(r.next+r.partSize, r.size)-1
+		r.next = end + 1
+
+		ch := make(chan s3Part, 1)
+		r.pending = append(r.pending, ch)
+		go func() {
+			ch <- r.download(start, end)
+		}()
+	}
+}
+
+func (r *s3RangeReader) download(start, end int64) s3Part {
+	rr, err := r.p.newResumingReader(r.ctx, r.key, r.etag, start, end)
+	if err != nil {
+		return s3Part{err: err}
+	}
+	defer rr.Close()
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(rr, data); err != nil {
+		return s3Part{err: fmt.Errorf("failed to read bytes %d-%d of object from S3, %w", start, end, err)}
+	}
+	return s3Part{data: data}
+}
+
+// Close stops any downloads in progress
+func (r *s3RangeReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// Pull downloads the object at path in concurrent ranges, or as a single
+// stream if it fits in one part. Either way, a dropped connection is resumed
+// from the last byte received.
+func (p *S3PushPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	head, err := p.s3client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               &p.bucket,
+		Key:                  &path,
+		SSECustomerAlgorithm: p.sseCustomerAlgorithm(),
+		SSECustomerKey:       optionalString(p.customerKey),
+		SSECustomerKeyMD5:    optionalString(p.customerKeyMD5),
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to get object from S3, %w", err)
+	}
+	size := aws.ToInt64(head.ContentLength)
+
+	r, err := p.pull(ctx, cancel, path, head.ETag, size)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Objects are verified whenever they have a recorded checksum, the
+	// configured algorithm only picks it and requires one to be recorded
+	algorithm, want, err := p.expectedChecksum(ctx, path, head.Metadata)
+	if err != nil && p.checksum == "" {
+		log.Printf("Not verifying %s, its checksum can't be read: %v\n", path, err)
+		return r, nil
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if want == "" {
+		if p.checksum == "" {
+			return r, nil
+		}
+		if !p.allowUnverified {
+			r.Close()
+			return nil, fmt.Errorf("%s has no %s checksum to verify it with (set s3_allow_unverified to restore it anyway)", path, p.checksum)
+		}
+		log.Printf("Not verifying %s, it has no %s checksum\n", path, p.checksum)
+		return r, nil
+	}
+	return &verifyingReader{r: r, h: newChecksumHash(algorithm), want: want, path: path}, nil
+}
+
+// pull reads the object of the given size, cancel is called once the
+// returned reader is closed
+func (p *S3PushPuller) pull(ctx context.Context, cancel context.CancelFunc, path string, etag *string, size int64) (io.ReadCloser, error) {
+	if p.downloadConcurrency <= 1 || size <= p.downloadPartSize {
+		r, err := p.newResumingReader(ctx, path, etag, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		return &cancelingReadCloser{ReadCloser: r, cancel: cancel}, nil
+	}
+
+	return &s3RangeReader{
+		ctx:         ctx,
+		cancel:      cancel,
+		p:           p,
+		key:         path,
+		etag:        etag,
+		size:        size,
+		partSize:    p.downloadPartSize,
+		concurrency: p.downloadConcurrency,
+	}, nil
+}
+
+// cancelingReadCloser cancels a context once it is closed
+type cancelingReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelingReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,797 @@
+package volback
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewAwsCfg_Credentials(t *testing.T) {
+	// Keep the default chain away from the real environment and IMDS
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	profiles := "[backup]\naws_access_key_id = profile-key\naws_secret_access_key = profile-secret\n"
+	if err := os.WriteFile(credentialsFile, []byte(profiles), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+
+	testCases := []struct {
+		name    string
+		loc     S3Options
+		wantKey string
+	}{
+		{
+			name:    "static when keys are set",
+			loc:     S3Options{S3_AccessKeyId: "static-key", S3_SecretAccessKey: "static-secret"},
+			wantKey: "static-key",
+		},
+		{
+			name:    "default chain without keys",
+			loc:     S3Options{},
+			wantKey: "env-key",
+		},
+		{
+			name:    "profile",
+			loc:     S3Options{S3_Credentials: "profile", S3_Profile: "backup"},
+			wantKey: "profile-key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			loc := tc.loc
+			loc.S3_Region = "us-east-1"
+			if err := validateS3Credentials(&loc); err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+
+			awsCfg, err := newAwsCfg(t.Context(), &loc, RetryPolicy{})
+			if err != nil {
+				t.Fatalf("unexpected error loading config: %v", err)
+			}
+			creds, err := awsCfg.Credentials.Retrieve(t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error retrieving credentials: %v", err)
+			}
+			if creds.AccessKeyID != tc.wantKey {
+				t.Errorf("expected access key %q, got %q", tc.wantKey, creds.AccessKeyID)
+			}
+		})
+	}
+}
+
+func TestValidateS3Credentials(t *testing.T) {
+	testCases := []struct {
+		name    string
+		loc     S3Options
+		wantErr string
+	}{
+		{
+			name:    "static without secret",
+			loc:     S3Options{S3_Credentials: "static", S3_AccessKeyId: "key"},
+			wantErr: "are required for static credentials",
+		},
+		{
+			name:    "keys with default chain",
+			loc:     S3Options{S3_Credentials: "default", S3_AccessKeyId: "key", S3_SecretAccessKey: "secret"},
+			wantErr: "can't be used with default credentials",
+		},
+		{
+			name:    "profile without name",
+			loc:     S3Options{S3_Credentials: "profile"},
+			wantErr: "s3_profile is required",
+		},
+		{
+			name:    "assume role without arn",
+			loc:     S3Options{S3_Credentials: "assume-role"},
+			wantErr: "s3_role_arn is required",
+		},
+		{
+			name: "assume role with keys",
+			loc: S3Options{
+				S3_Credentials:     "assume-role",
+				S3_RoleArn:         "arn:aws:iam::123456789012:role/backup",
+				S3_ExternalId:      "volback",
+				S3_AccessKeyId:     "key",
+				S3_SecretAccessKey: "secret",
+			},
+		},
+		{
+			name:    "web identity without token",
+			loc:     S3Options{S3_Credentials: "web-identity", S3_RoleArn: "arn:aws:iam::123456789012:role/backup"},
+			wantErr: "s3_web_identity_token_file are required",
+		},
+		{
+			name:    "unknown",
+			loc:     S3Options{S3_Credentials: "magic"},
+			wantErr: "invalid s3_credentials",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateS3Credentials(&tc.loc)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// fakeS3 implements just enough of the S3 REST API to put, tag and get
+// objects in a single bucket, with path style addressing
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+	tags    map[string]string
+
+	objectLock bool
+
+	// The next drops GETs hang up after dropAfter bytes
+	drops     int
+	dropAfter int
+	ranges    []string
+
+	uploads      map[string]*fakeUpload
+	nextUploadId int
+	aborted      []string
+	// The next failParts part uploads fail with a server error
+	failParts int
+}
+
+// fakeUpload is a multipart upload in progress
+type fakeUpload struct {
+	key       string
+	header    http.Header
+	parts     map[int][]byte
+	initiated time.Time
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: map[string][]byte{},
+		headers: map[string]http.Header{},
+		tags:    map[string]string{},
+		uploads: map[string]*fakeUpload{},
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && q.Has("object-lock"):
+		if !f.objectLock {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<Error><Code>ObjectLockConfigurationNotFoundError</Code></Error>"))
+			return
+		}
+		w.Write([]byte("<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>"))
+	case r.Method == http.MethodPut && q.Has("tagging"):
+		f.tags[key] = string(body)
+	case r.Method == http.MethodGet && q.Has("tagging"):
+		fmt.Fprint(w, f.tags[key])
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.nextUploadId++
+		id := strconv.Itoa(f.nextUploadId)
+		f.uploads[id] = &fakeUpload{key: key, header: r.Header.Clone(), parts: map[int][]byte{}, initiated: time.Now()}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, id)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		upload, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if f.failParts > 0 {
+			f.failParts--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		upload.parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part%d"`, n))
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		upload, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var obj []byte
+		for n := 1; n <= len(upload.parts); n++ {
+			obj = append(obj, upload.parts[n]...)
+		}
+		f.objects[key] = obj
+		f.headers[key] = upload.header
+		delete(f.uploads, q.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Key>%s</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		if upload, ok := f.uploads[q.Get("uploadId")]; ok {
+			f.aborted = append(f.aborted, upload.key)
+			delete(f.uploads, q.Get("uploadId"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && key == "" && q.Has("uploads"):
+		fmt.Fprint(w, "<ListMultipartUploadsResult><IsTruncated>false</IsTruncated>")
+		for id, upload := range f.uploads {
+			if strings.HasPrefix(upload.key, q.Get("prefix")) {
+				fmt.Fprintf(w, "<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>%s</Initiated></Upload>", upload.key, id, upload.initiated.UTC().Format(time.RFC3339))
+			}
+		}
+		fmt.Fprint(w, "</ListMultipartUploadsResult>")
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		f.headers[key] = r.Header.Clone()
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range f.headers[key] {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+				w.Header()[k] = v
+			}
+		}
+		w.Header().Set("ETag", `"etag"`)
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(obj)))
+			return
+		}
+
+		status := http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" {
+			f.ranges = append(f.ranges, rng)
+			var start, end int
+			if n, _ := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); n < 2 {
+				end = len(obj) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj)))
+			obj = obj[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj)))
+		w.WriteHeader(status)
+		if f.drops > 0 && len(obj) > f.dropAfter {
+			// Hang up part way through the body
+			f.drops--
+			w.Write(obj[:f.dropAfter])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(obj)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newTestS3PushPuller points an S3PushPuller at a fake S3 server
+func newTestS3PushPuller(t *testing.T, loc S3Options) (*S3PushPuller, *fakeS3) {
+	t.Helper()
+	return newTestS3PushPullerWithRetry(t, loc, RetryPolicy{})
+}
+
+// newTestS3PushPullerWithRetry points an S3PushPuller configured by loc and
+// retry at a fake S3 server
+func newTestS3PushPullerWithRetry(t *testing.T, loc S3Options, retry RetryPolicy) (*S3PushPuller, *fakeS3) {
+	t.Helper()
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	loc.S3_Endpoint = server.URL
+	loc.S3_Bucket = "backups"
+	loc.S3_Region = "us-east-1"
+	loc.S3_AccessKeyId, loc.S3_SecretAccessKey = "key", "secret"
+	loc.S3_ForcePathStyle = true
+	p, err := newS3PushPuller(t.Context(), &loc, retry)
+	if err != nil {
+		t.Fatalf("unexpected error setting up s3: %v", err)
+	}
+	return p, fake
+}
+
+func TestS3PushPuller_ObjectOptions(t *testing.T) {
+	p, fake := newTestS3PushPuller(t, S3Options{
+		S3_StorageClass:         "STANDARD_IA",
+		S3_ServerSideEncryption: "sse-kms",
+		S3_SseKmsKeyId:          "backup-key",
+		S3_Tags:                 map[string]string{"retention": "90d"},
+		S3_Metadata:             map[string]string{"team": "storage"},
+	})
+	given := "an encrypted backup"
+
+	err := p.PushWithMetadata(t.Context(), strings.NewReader(given), "vw/backup", map[string]string{FormatVersionMetadataKey: FormatVersion})
+	if err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+
+	h := fake.headers["vw/backup"]
+	for header, want := range map[string]string{
+		"X-Amz-Storage-Class":                         "STANDARD_IA",
+		"X-Amz-Server-Side-Encryption":                "aws:kms",
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "backup-key",
+		"X-Amz-Tagging":                               "retention=90d",
+		"X-Amz-Meta-Team":                             "storage",
+		"X-Amz-Meta-Volback-Format-Version":           FormatVersion,
+	} {
+		if got := h.Get(header); got != want {
+			t.Errorf("expected %s to be %q, got %q", header, want, got)
+		}
+	}
+	if tags := fake.tags["vw/backup"]; !strings.Contains(tags, "<Key>volback-size</Key><Value>19</Value>") {
+		t.Errorf("expected the size to be tagged, got: %s", tags)
+	}
+
+	info, err := p.Stat(t.Context(), "vw/backup")
+	if err != nil {
+		t.Fatalf("unexpected error stating: %v", err)
+	}
+	if info.Metadata[FormatVersionMetadataKey] != FormatVersion {
+		t.Errorf("expected metadata to be read back, got: %v", info.Metadata)
+	}
+}
+
+func TestS3PushPuller_SseC(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	p, fake := newTestS3PushPuller(t, S3Options{
+		S3_ServerSideEncryption: "sse-c",
+		S3_SseCustomerKey:       key,
+	})
+
+	if err := p.Push(t.Context(), strings.NewReader("an encrypted backup"), "vw/backup"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+	h := fake.headers["vw/backup"]
+	if h.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "AES256" || h.Get("X-Amz-Server-Side-Encryption-Customer-Key") != key {
+		t.Errorf("expected the customer key to be sent, got: %v", h)
+	}
+	if h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") == "" {
+		t.Errorf("expected the customer key md5 to be sent")
+	}
+}
+
+func TestValidateS3Objects(t *testing.T) {
+	testCases := []struct {
+		name    string
+		loc     S3Options
+		wantErr string
+	}{
+		{
+			name: "valid",
+			loc:  S3Options{S3_StorageClass: "DEEP_ARCHIVE", S3_ServerSideEncryption: "sse-s3"},
+		},
+		{
+			name:    "unknown storage class",
+			loc:     S3Options{S3_StorageClass: "COLD"},
+			wantErr: "invalid s3_storage_class",
+		},
+		{
+			name:    "kms key without sse-kms",
+			loc:     S3Options{S3_ServerSideEncryption: "sse-s3", S3_SseKmsKeyId: "key"},
+			wantErr: "s3_sse_kms_key_id can only be used with sse-kms",
+		},
+		{
+			name:    "short customer key",
+			loc:     S3Options{S3_ServerSideEncryption: "sse-c", S3_SseCustomerKey: "c2hvcnQ="},
+			wantErr: "256-bit key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateS3Objects(&tc.loc)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestS3PushPuller_ObjectLock(t *testing.T) {
+	p, fake := newTestS3PushPuller(t, S3Options{
+		S3_ObjectLockMode:      "COMPLIANCE",
+		S3_ObjectLockRetention: "30d",
+		S3_ObjectLockLegalHold: true,
+	})
+
+	if err := p.checkObjectLock(t.Context()); err == nil {
+		t.Fatalf("expected preflight to fail without object lock")
+	}
+	fake.objectLock = true
+	if err := p.checkObjectLock(t.Context()); err != nil {
+		t.Fatalf("unexpected preflight error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := p.checkObjectLock(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected preflight to be cancelled, got: %v", err)
+	}
+
+	before := time.Now()
+	if err := p.Push(t.Context(), strings.NewReader("an encrypted backup"), "vw/backup"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+
+	h := fake.headers["vw/backup"]
+	if got := h.Get("X-Amz-Object-Lock-Mode"); got != "COMPLIANCE" {
+		t.Errorf("expected lock mode COMPLIANCE, got %q", got)
+	}
+	if got := h.Get("X-Amz-Object-Lock-Legal-Hold"); got != "ON" {
+		t.Errorf("expected legal hold ON, got %q", got)
+	}
+	until, err := time.Parse(time.RFC3339, h.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	if err != nil {
+		t.Fatalf("invalid retain until date: %v", err)
+	}
+	if want := before.Add(30 * 24 * time.Hour).Truncate(time.Second); until.Before(want) {
+		t.Errorf("expected retention until at least %s, got %s", want, until)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	for given, want := range map[string]time.Duration{
+		"30d":  30 * 24 * time.Hour,
+		"720h": 720 * time.Hour,
+	} {
+		got, err := parseRetention(given)
+		if err != nil || got != want {
+			t.Errorf("parseRetention(%q) = %s, %v; want %s", given, got, err, want)
+		}
+	}
+	for _, given := range []string{"", "0d", "-1h", "soon"} {
+		if _, err := parseRetention(given); err == nil {
+			t.Errorf("expected parseRetention(%q) to fail", given)
+		}
+	}
+}
+
+func TestS3PushPuller_Multipart(t *testing.T) {
+	const partSize = 5 << 20
+	p, fake := newTestS3PushPuller(t, S3Options{S3_PartSize: partSize, S3_Concurrency: 2})
+	given := bytes.Repeat([]byte("0123456789abcdef"), (2*partSize+1024)/16)
+
+	if err := p.Push(t.Context(), bytes.NewReader(given), "vw/backup"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+	if !bytes.Equal(given, fake.objects["vw/backup"]) {
+		t.Errorf("expected the parts to be assembled into the object")
+	}
+	if fake.nextUploadId != 1 {
+		t.Errorf("expected a single multipart upload, got %d", fake.nextUploadId)
+	}
+}
+
+func TestS3PushPuller_RetryParts(t *testing.T) {
+	const partSize = 5 << 20
+	p, fake := newTestS3PushPullerWithRetry(t,
+		S3Options{S3_PartSize: partSize, S3_Concurrency: 1},
+		RetryPolicy{Retries: 4, Backoff: time.Millisecond, MaxBackoff: defaultRetryMaxBackoff},
+	)
+	// More failures than the SDK retries by default
+	fake.failParts = 4
+	given := bytes.Repeat([]byte("0123456789abcdef"), (2*partSize+1024)/16)
+
+	// The pipe can't be rewound, so only the failed part is sent again
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(given)
+		pw.Close()
+	}()
+	if err := p.Push(t.Context(), pr, "vw/backup"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+	if !bytes.Equal(given, fake.objects["vw/backup"]) {
+		t.Errorf("expected the retried parts to be assembled into the object")
+	}
+	if fake.nextUploadId != 1 {
+		t.Errorf("expected a single multipart upload, got %d", fake.nextUploadId)
+	}
+}
+
+// cancelingReader cancels once n bytes have been read, then fails like a
+// source that was cancelled along with the push
+type cancelingReader struct {
+	r      io.Reader
+	n      int
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *cancelingReader) Read(b []byte) (int, error) {
+	if c.n <= 0 {
+		c.cancel()
+		return 0, c.ctx.Err()
+	}
+	n, err := c.r.Read(b[:min(len(b), c.n)])
+	c.n -= n
+	return n, err
+}
+
+func TestS3PushPuller_CancelAbortsUpload(t *testing.T) {
+	const partSize = 5 << 20
+	p, fake := newTestS3PushPuller(t, S3Options{S3_PartSize: partSize, S3_Concurrency: 1})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	r := &cancelingReader{r: bytes.NewReader(make([]byte, 2*partSize)), n: partSize + 1024, ctx: ctx, cancel: cancel}
+	err := p.Push(ctx, r, "vw/backup")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the push to be cancelled, got: %v", err)
+	}
+	if len(fake.aborted) != 1 || fake.aborted[0] != "vw/backup" {
+		t.Errorf("expected the multipart upload to be aborted, got: %v", fake.aborted)
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("expected no uploads to be left behind, got: %v", fake.uploads)
+	}
+	if _, ok := fake.objects["vw/backup"]; ok {
+		t.Errorf("expected nothing to be stored")
+	}
+}
+
+func TestS3PartSize(t *testing.T) {
+	testCases := []struct {
+		name         string
+		partSize     int64
+		expectedSize int64
+		want         int64
+	}{
+		{name: "explicit", partSize: 64 << 20, expectedSize: 100 << 30, want: 64 << 20},
+		{name: "default", want: 0},
+		{name: "small upload", expectedSize: 1 << 30, want: 5 << 20},
+		{name: "1 TiB", expectedSize: 1 << 40, want: 105 << 20},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := s3PartSize(tc.partSize, tc.expectedSize)
+			if got != tc.want {
+				t.Errorf("expected part size %d, got %d", tc.want, got)
+			}
+			if tc.expectedSize > 0 && got*10000 < tc.expectedSize {
+				t.Errorf("part size %d can't fit %d bytes in 10000 parts", got, tc.expectedSize)
+			}
+		})
+	}
+}
+
+func TestS3PushPuller_AbortStaleUploads(t *testing.T) {
+	p, fake := newTestS3PushPuller(t, S3Options{})
+	fake.uploads["1"] = &fakeUpload{key: "vw/stale", initiated: time.Now().Add(-48 * time.Hour)}
+	fake.uploads["2"] = &fakeUpload{key: "vw/running", initiated: time.Now()}
+	fake.uploads["3"] = &fakeUpload{key: "other/stale", initiated: time.Now().Add(-48 * time.Hour)}
+
+	aborted, err := p.AbortStaleUploads(t.Context(), "vw/", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error aborting uploads: %v", err)
+	}
+	if len(aborted) != 1 || aborted[0] != "vw/stale" {
+		t.Errorf("expected only vw/stale to be aborted, got: %v", aborted)
+	}
+	if len(fake.uploads) != 2 {
+		t.Errorf("expected the other uploads to be left alone, got: %v", fake.uploads)
+	}
+}
+
+func TestS3PushPuller_RangedPull(t *testing.T) {
+	testCases := []struct {
+		name       string
+		loc        S3Options
+		drops      int
+		dropAfter  int
+		wantRanges int
+		wantErr    bool
+	}{
+		{
+			name:       "parallel ranges",
+			loc:        S3Options{S3_DownloadPartSize: 1000, S3_DownloadConcurrency: 3},
+			wantRanges: 10,
+		},
+		{
+			name:       "single stream",
+			loc:        S3Options{S3_DownloadConcurrency: 1},
+			wantRanges: 0,
+		},
+		{
+			name:       "resume a range",
+			loc:        S3Options{S3_DownloadPartSize: 1000, S3_DownloadConcurrency: 3},
+			drops:      2,
+			dropAfter:  100,
+			wantRanges: 12,
+		},
+		{
+			name:       "resume a single stream",
+			loc:        S3Options{S3_DownloadConcurrency: 1},
+			drops:      2,
+			dropAfter:  100,
+			wantRanges: 2,
+		},
+		{
+			// Every resume receives some bytes, so drops spread over a long
+			// download never add up to a failure
+			name:       "resume many spaced out drops",
+			loc:        S3Options{S3_DownloadConcurrency: 1},
+			drops:      s3MaxResumes + 5,
+			dropAfter:  100,
+			wantRanges: s3MaxResumes + 5,
+		},
+		{
+			name:    "give up",
+			loc:     S3Options{S3_DownloadConcurrency: 1},
+			drops:   100,
+			wantErr: true,
+		},
+	}
+
+	given := make([]byte, 9500)
+	for i := range given {
+		given[i] = byte(i)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, fake := newTestS3PushPuller(t, tc.loc)
+			fake.objects["vw/backup"] = given
+			fake.drops, fake.dropAfter = tc.drops, tc.dropAfter
+
+			r, err := p.Pull(t.Context(), "vw/backup")
+			if err != nil {
+				t.Fatalf("unexpected error pulling: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			r.(io.Closer).Close()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error after too many dropped connections")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error reading: %v", err)
+			}
+			if !bytes.Equal(given, got) {
+				t.Errorf("pulled contents mismatch")
+			}
+			if len(fake.ranges) != tc.wantRanges {
+				t.Errorf("expected %d ranged requests, got: %v", tc.wantRanges, fake.ranges)
+			}
+		})
+	}
+}
+
+func TestS3PushPuller_Checksum(t *testing.T) {
+	for _, algorithm := range []string{"sha256", "crc32c"} {
+		t.Run(algorithm, func(t *testing.T) {
+			p, fake := newTestS3PushPuller(t, S3Options{S3_Checksum: algorithm, S3_DownloadPartSize: 4})
+			given := "an encrypted backup"
+
+			if err := p.Push(t.Context(), strings.NewReader(given), "vw/backup"); err != nil {
+				t.Fatalf("unexpected error pushing: %v", err)
+			}
+			if !strings.Contains(fake.tags["vw/backup"], "<Key>volback-"+algorithm+"</Key>") {
+				t.Fatalf("expected the checksum to be tagged, got: %s", fake.tags["vw/backup"])
+			}
+
+			r, err := p.Pull(t.Context(), "vw/backup")
+			if err != nil {
+				t.Fatalf("unexpected error pulling: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error verifying: %v", err)
+			}
+			if string(got) != given {
+				t.Errorf("expected %q, got %q", given, got)
+			}
+
+			// Corrupt the object at rest
+			fake.objects["vw/backup"][3] ^= 0xff
+			r, err = p.Pull(t.Context(), "vw/backup")
+			if err != nil {
+				t.Fatalf("unexpected error pulling: %v", err)
+			}
+			if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+				t.Errorf("expected a checksum mismatch, got: %v", err)
+			}
+
+			// The recorded checksum is verified without an algorithm configured
+			p.checksum = ""
+			r, err = p.Pull(t.Context(), "vw/backup")
+			if err != nil {
+				t.Fatalf("unexpected error pulling: %v", err)
+			}
+			if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+				t.Errorf("expected a checksum mismatch without an algorithm configured, got: %v", err)
+			}
+			p.checksum = algorithm
+
+			// Without its tag the object can't be verified
+			delete(fake.tags, "vw/backup")
+			if _, err := p.Pull(t.Context(), "vw/backup"); err == nil || !strings.Contains(err.Error(), "no "+algorithm+" checksum") {
+				t.Errorf("expected pulling an unverifiable object to fail, got: %v", err)
+			}
+			p.checksum = ""
+			if _, err := p.Pull(t.Context(), "vw/backup"); err != nil {
+				t.Errorf("unexpected error pulling unverified without an algorithm configured: %v", err)
+			}
+			p.checksum = algorithm
+			p.allowUnverified = true
+			if _, err := p.Pull(t.Context(), "vw/backup"); err != nil {
+				t.Errorf("unexpected error pulling unverified: %v", err)
+			}
+		})
+	}
+}
+
+func TestS3PushPuller_ChecksumMetadata(t *testing.T) {
+	p, fake := newTestS3PushPuller(t, S3Options{S3_Checksum: "sha256"})
+	given := "an encrypted backup"
+	sum := sha256.Sum256([]byte(given))
+	want := hex.EncodeToString(sum[:])
+
+	// The tagged sha256 is the checksum replicate compares
+	if err := p.Push(t.Context(), strings.NewReader(given), "vw/backup"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+	info, err := p.Stat(t.Context(), "vw/backup")
+	if err != nil {
+		t.Fatalf("unexpected error stating: %v", err)
+	}
+	if got := info.Metadata[ChecksumMetadataKey]; got != want {
+		t.Errorf("expected %s to be %s, got %q", ChecksumMetadataKey, want, got)
+	}
+
+	// A checksum recorded in metadata by replicate verifies pulls in place of
+	// the tag
+	if err := p.PushWithMetadata(t.Context(), strings.NewReader(given), "vw/replicated", map[string]string{ChecksumMetadataKey: want}); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+	delete(fake.tags, "vw/replicated")
+	fake.objects["vw/replicated"][3] ^= 0xff
+	r, err := p.Pull(t.Context(), "vw/replicated")
+	if err != nil {
+		t.Fatalf("unexpected error pulling: %v", err)
+	}
+	if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch, got: %v", err)
+	}
+}
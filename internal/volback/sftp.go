@@ -0,0 +1,237 @@
+package volback
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jacobmiller22/volume-backup/backend"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	backend.Register("sftp", backend.Backend{
+		NewPuller: func(_ context.Context, loc *backend.Location) (Puller, error) {
+			return newSftpPushPullerFromLocation(loc)
+		},
+		NewPusher: func(_ context.Context, loc *backend.Location) (Pusher, error) {
+			return newSftpPushPullerFromLocation(loc)
+		},
+		Options: &SftpOptions{},
+		Validate: func(loc *backend.Location) error {
+			opts, err := decodeOptions[SftpOptions](loc)
+			if err != nil {
+				return err
+			}
+			if opts.Sftp_Host == "" || opts.Sftp_User == "" || opts.Sftp_PrivateKeyPath == "" {
+				return fmt.Errorf("sftp_host, sftp_user and sftp_private_key_path are required")
+			}
+			return nil
+		},
+	})
+}
+
+// SftpOptions configure an sftp location
+type SftpOptions struct {
+	Sftp_Host           string `json:"sftp_host" usage:"Hostname of the sftp server"`
+	Sftp_Port           int    `json:"sftp_port" usage:"Port of the sftp server, defaults to 22"`
+	Sftp_User           string `json:"sftp_user" usage:"User to log in to the sftp server as"`
+	Sftp_PrivateKeyPath string `json:"sftp_private_key_path" usage:"Path to the private key to authenticate with"`
+	Sftp_KnownHostsPath string `json:"sftp_known_hosts_path" usage:"Path to the known_hosts file used to verify the server, defaults to ~/.ssh/known_hosts"`
+}
+
+func newSftpPushPullerFromLocation(loc *backend.Location) (*SftpPushPuller, error) {
+	opts, err := decodeOptions[SftpOptions](loc)
+	if err != nil {
+		return nil, err
+	}
+	return newSftpPushPuller(opts)
+}
+
+func newSshConfig(opts *SftpOptions) (*ssh.ClientConfig, error) {
+	key, err := os.ReadFile(opts.Sftp_PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+
+	knownHostsPath := opts.Sftp_KnownHostsPath
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("locating known_hosts: %w", err)
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading known_hosts: %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            opts.Sftp_User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sftpConnectTimeout,
+	}, nil
+}
+
+func newSftpPushPuller(opts *SftpOptions) (*SftpPushPuller, error) {
+	sshCfg, err := newSshConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	port := opts.Sftp_Port
+	if port == 0 {
+		port = 22
+	}
+
+	return &SftpPushPuller{
+		addr:   net.JoinHostPort(opts.Sftp_Host, strconv.Itoa(port)),
+		sshCfg: sshCfg,
+	}, nil
+}
+
+// SftpPushPuller stores backups on an SSH server. A new connection is made
+// for every Pull and Push.
+type SftpPushPuller struct {
+	addr   string
+	sshCfg *ssh.ClientConfig
+}
+
+// sftpConnectTimeout bounds connecting to the server and the SSH handshake
+const sftpConnectTimeout = 30 * time.Second
+
+func (p *SftpPushPuller) connect(ctx context.Context) (*ssh.Client, *sftp.Client, error) {
+	dialer := net.Dialer{Timeout: p.sshCfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to %s: %w", p.addr, err)
+	}
+
+	// The handshake takes no ctx, so closing the connection is what stops it
+	if p.sshCfg.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.sshCfg.Timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, p.addr, p.sshCfg)
+	if !stop() {
+		// ctx was cancelled during the handshake, which closed conn
+		if err == nil {
+			c.Close()
+		}
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("connecting to %s: %w", p.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	sshClient := ssh.NewClient(c, chans, reqs)
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, nil, fmt.Errorf("starting sftp session: %w", err)
+	}
+	return sshClient, sftpClient, nil
+}
+
+// sftpReader closes the remote file along with the connection it was read over
+type sftpReader struct {
+	*sftp.File
+	sftpClient *sftp.Client
+	sshClient  *ssh.Client
+	// stop stops closing the connection when the pull is cancelled
+	stop func() bool
+}
+
+func (r *sftpReader) Close() error {
+	r.stop()
+	r.File.Close()
+	r.sftpClient.Close()
+	return r.sshClient.Close()
+}
+
+func (p *SftpPushPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	sshClient, sftpClient, err := p.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := sftpClient.Open(path)
+	if err != nil {
+		sftpClient.Close()
+		sshClient.Close()
+		return nil, fmt.Errorf("failed to open %s over sftp: %w", path, err)
+	}
+
+	// Closing the connection unblocks any read in progress
+	stop := context.AfterFunc(ctx, func() { sshClient.Close() })
+	return &sftpReader{fd, sftpClient, sshClient, stop}, nil
+}
+
+func (p *SftpPushPuller) Push(ctx context.Context, r io.Reader, dstPath string) error {
+	sshClient, sftpClient, err := p.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+	defer sftpClient.Close()
+	// Closing the connection unblocks the upload if the push is cancelled
+	stop := context.AfterFunc(ctx, func() { sshClient.Close() })
+	defer stop()
+
+	if err := sftpClient.MkdirAll(path.Dir(dstPath)); err != nil {
+		return fmt.Errorf("failed to create %s over sftp: %w", path.Dir(dstPath), err)
+	}
+
+	// Upload next to the destination and rename it into place once complete,
+	// so a failed push leaves the previous backup intact
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	tmpPath := dstPath + ".tmp-" + hex.EncodeToString(id)
+
+	if err := p.upload(sftpClient, r, tmpPath); err != nil {
+		// The connection is gone if the push was cancelled, in which case the
+		// temporary file is left for the server to clean up
+		sftpClient.Remove(tmpPath)
+		return err
+	}
+	if err := sftpClient.PosixRename(tmpPath, dstPath); err != nil {
+		sftpClient.Remove(tmpPath)
+		return fmt.Errorf("failed to rename %s to %s over sftp: %w", tmpPath, dstPath, err)
+	}
+	return nil
+}
+
+// upload writes r to a new file at path
+func (p *SftpPushPuller) upload(sftpClient *sftp.Client, r io.Reader, path string) error {
+	fd, err := sftpClient.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("failed to create %s over sftp: %w", path, err)
+	}
+	defer fd.Close()
+
+	if _, err := fd.ReadFrom(r); err != nil {
+		return fmt.Errorf("failed to write %s over sftp: %w", path, err)
+	}
+
+	return fd.Close()
+}
@@ -0,0 +1,287 @@
+package volback
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startSftpServer serves dir over sftp to clients presenting clientKey. It
+// returns the address it is listening on and a known_hosts file trusting it.
+func startSftpServer(t *testing.T, dir string, clientKey ssh.PublicKey) (string, string) {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("unexpected error creating host signer: %v", err)
+	}
+
+	serverCfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	serverCfg.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftpConn(conn, serverCfg, dir)
+		}
+	}()
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{ln.Addr().String()}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsPath, []byte(line+"\n"), 0600); err != nil {
+		t.Fatalf("unexpected error writing known_hosts: %v", err)
+	}
+
+	return ln.Addr().String(), knownHostsPath
+}
+
+func serveSftpConn(conn net.Conn, serverCfg *ssh.ServerConfig, dir string) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverCfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(dir))
+				if err != nil {
+					channel.Close()
+					return
+				}
+				server.Serve()
+				channel.Close()
+			}
+		}()
+	}
+}
+
+func writeClientKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating client key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("unexpected error marshalling client key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("unexpected error creating client signer: %v", err)
+	}
+
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("unexpected error writing client key: %v", err)
+	}
+	return keyPath, signer.PublicKey()
+}
+
+// newTestSftpPushPuller starts an sftp server serving serverDir and connects
+// to it
+func newTestSftpPushPuller(t *testing.T, serverDir string) *SftpPushPuller {
+	t.Helper()
+	keyPath, pubKey := writeClientKey(t)
+	addr, knownHostsPath := startSftpServer(t, serverDir, pubKey)
+
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+
+	p, err := newSftpPushPuller(&SftpOptions{
+		Sftp_Host:           host,
+		Sftp_Port:           portNum,
+		Sftp_User:           "volback",
+		Sftp_PrivateKeyPath: keyPath,
+		Sftp_KnownHostsPath: knownHostsPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating sftp push puller: %v", err)
+	}
+	return p
+}
+
+func TestSftpPushPuller(t *testing.T) {
+	serverDir := t.TempDir()
+	p := newTestSftpPushPuller(t, serverDir)
+
+	given := "backup contents sent over sftp"
+	if err := p.Push(t.Context(), bytes.NewReader([]byte(given)), "backups/nested/backup.ct"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+
+	onDisk, err := os.ReadFile(filepath.Join(serverDir, "backups", "nested", "backup.ct"))
+	if err != nil {
+		t.Fatalf("unexpected error reading pushed file: %v", err)
+	}
+	if diff := cmp.Diff(given, string(onDisk)); diff != "" {
+		t.Errorf("pushed contents mismatch (-want +got):\n%s", diff)
+	}
+
+	r, err := p.Pull(t.Context(), "backups/nested/backup.ct")
+	if err != nil {
+		t.Fatalf("unexpected error pulling: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error reading pulled file: %v", err)
+	}
+	r.(io.Closer).Close()
+
+	if diff := cmp.Diff(given, string(got)); diff != "" {
+		t.Errorf("pulled contents mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSftpPushPuller_FailedPushKeepsPrevious(t *testing.T) {
+	serverDir := t.TempDir()
+	p := newTestSftpPushPuller(t, serverDir)
+
+	previous := "the last good backup"
+	if err := p.Push(t.Context(), strings.NewReader(previous), "backups/backup.ct"); err != nil {
+		t.Fatalf("unexpected error pushing: %v", err)
+	}
+
+	broken := &faultyReader{r: strings.NewReader("a backup that fails part way"), err: ErrInjected, errAfter: 8}
+	if err := p.Push(t.Context(), broken, "backups/backup.ct"); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected injected error, got: %v", err)
+	}
+
+	onDisk, err := os.ReadFile(filepath.Join(serverDir, "backups", "backup.ct"))
+	if err != nil {
+		t.Fatalf("unexpected error reading pushed file: %v", err)
+	}
+	if diff := cmp.Diff(previous, string(onDisk)); diff != "" {
+		t.Errorf("expected the previous backup to be intact (-want +got):\n%s", diff)
+	}
+	entries, err := os.ReadDir(filepath.Join(serverDir, "backups"))
+	if err != nil {
+		t.Fatalf("unexpected error listing backups: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected the temporary upload to be removed, got: %v", entries)
+	}
+}
+
+func TestSftpPushPuller_UnknownHost(t *testing.T) {
+	keyPath, pubKey := writeClientKey(t)
+	addr, _ := startSftpServer(t, t.TempDir(), pubKey)
+
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+
+	// An empty known_hosts file trusts nobody
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHostsPath, nil, 0600); err != nil {
+		t.Fatalf("unexpected error writing known_hosts: %v", err)
+	}
+
+	p, err := newSftpPushPuller(&SftpOptions{
+		Sftp_Host:           host,
+		Sftp_Port:           portNum,
+		Sftp_User:           "volback",
+		Sftp_PrivateKeyPath: keyPath,
+		Sftp_KnownHostsPath: knownHostsPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating sftp push puller: %v", err)
+	}
+
+	if err := p.Push(t.Context(), bytes.NewReader([]byte("data")), "backup.ct"); err == nil {
+		t.Fatalf("expected host key verification to fail, got nil")
+	}
+}
+
+func TestSftpPushPuller_CancelledHandshake(t *testing.T) {
+	keyPath, _ := writeClientKey(t)
+
+	// A server that accepts connections but never answers the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHostsPath, nil, 0600); err != nil {
+		t.Fatalf("unexpected error writing known_hosts: %v", err)
+	}
+	p, err := newSftpPushPuller(&SftpOptions{
+		Sftp_Host:           host,
+		Sftp_Port:           portNum,
+		Sftp_User:           "volback",
+		Sftp_PrivateKeyPath: keyPath,
+		Sftp_KnownHostsPath: knownHostsPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating sftp push puller: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Push(ctx, strings.NewReader("data"), "backup.ct"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the handshake to be cancelled, got: %v", err)
+	}
+}
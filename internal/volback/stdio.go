@@ -0,0 +1,36 @@
+package volback
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/jacobmiller22/volume-backup/backend"
+)
+
+func init() {
+	backend.Register("stdin", backend.Backend{
+		NewPuller: func(context.Context, *backend.Location) (Puller, error) { return &StdioPushPuller{in: os.Stdin}, nil },
+	})
+	backend.Register("stdout", backend.Backend{
+		NewPusher: func(context.Context, *backend.Location) (Pusher, error) { return &StdioPushPuller{out: os.Stdout}, nil },
+	})
+}
+
+// StdioPushPuller pulls from stdin and pushes to stdout. Streams are passed
+// through as is, so nothing is archived on backup or unpacked on restore.
+type StdioPushPuller struct {
+	in  io.Reader
+	out io.Writer
+}
+
+// Pull returns stdin, the path is ignored
+func (p *StdioPushPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	return p.in, nil
+}
+
+// Push copies r to stdout, the path is ignored
+func (p *StdioPushPuller) Push(ctx context.Context, r io.Reader, path string) error {
+	_, err := io.Copy(p.out, r)
+	return err
+}
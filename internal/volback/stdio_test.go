@@ -0,0 +1,47 @@
+package volback
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jacobmiller22/volume-backup/internal/config"
+)
+
+func TestStdioRoundTrip(t *testing.T) {
+	given := "raw stream that is never archived"
+
+	cfg := &config.Config{
+		Source:      config.Location{Kind: "stdin"},
+		Destination: config.Location{Kind: "stdout"},
+	}
+	cfg.Encryption.Key = "temp size 16 key"
+
+	var encrypted bytes.Buffer
+	backup, err := NewExecutor(cfg, &StdioPushPuller{in: strings.NewReader(given)}, &StdioPushPuller{out: &encrypted})
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+
+	if _, err := backup.Backup(t.Context()); err != nil {
+		t.Fatalf("unexpected error backing up: %v", err)
+	}
+	if encrypted.String() == given {
+		t.Fatalf("expected stream to be encrypted")
+	}
+
+	var restored bytes.Buffer
+	restore, err := NewExecutor(cfg, &StdioPushPuller{in: &encrypted}, &StdioPushPuller{out: &restored})
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+
+	if _, err := restore.Restore(t.Context()); err != nil {
+		t.Fatalf("unexpected error restoring: %v", err)
+	}
+
+	if diff := cmp.Diff(given, restored.String()); diff != "" {
+		t.Errorf("restored stream mismatch (-want +got):\n%s", diff)
+	}
+}
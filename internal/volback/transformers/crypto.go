@@ -18,14 +18,14 @@ func (tf *EncryptionTransformer) Transform(ctx context.Context, input io.Reader,
 	// Read all of the data before doing our encryption
 	buf, err := io.ReadAll(input)
 	if err != nil {
-		return fmt.Errorf("error reading all: %v", err)
+		return fmt.Errorf("error reading all: %w", err)
 	}
 	r := bytes.NewReader(buf)
 
 	tf.Encryptor.SetReader(r)
 
 	if n, err := io.Copy(output, tf.Encryptor); err != nil {
-		return fmt.Errorf("failed to encrypt after reading %d bytes: %v", n, err)
+		return fmt.Errorf("failed to encrypt after reading %d bytes: %w", n, err)
 	}
 	return nil
 }
@@ -37,14 +37,14 @@ type DecryptionTransformer struct {
 func (tf *DecryptionTransformer) Transform(ctx context.Context, input io.Reader, output io.Writer) error {
 	buf, err := io.ReadAll(input)
 	if err != nil {
-		return fmt.Errorf("error reading all: %v", err)
+		return fmt.Errorf("error reading all: %w", err)
 	}
 	r := bytes.NewReader(buf)
 
 	tf.Decryptor.SetReader(r)
 
 	if n, err := io.Copy(output, tf.Decryptor); err != nil {
-		return fmt.Errorf("failed to encrypt after reading %d bytes: %v", n, err)
+		return fmt.Errorf("failed to encrypt after reading %d bytes: %w", n, err)
 	}
 	return nil
 }
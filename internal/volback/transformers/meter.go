@@ -0,0 +1,33 @@
+package transformers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// MeterTransformer copies its input to its output as is, adding every byte
+// it copies to Count as it goes so progress can be read while it runs
+type MeterTransformer struct {
+	Count *atomic.Int64
+}
+
+func (tf *MeterTransformer) Transform(ctx context.Context, input io.Reader, output io.Writer) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := input.Read(buf)
+		if n > 0 {
+			if _, werr := output.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			tf.Count.Add(int64(n))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading: %w", err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package transformers
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMeterTransformer(t *testing.T) {
+	given := bytes.Repeat([]byte("metered "), 10000)
+
+	var count atomic.Int64
+	count.Store(5)
+	tf := &MeterTransformer{Count: &count}
+
+	var out bytes.Buffer
+	if err := tf.Transform(t.Context(), bytes.NewReader(given), &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(given, out.Bytes()) {
+		t.Errorf("expected the input to be copied as is")
+	}
+	if got, want := count.Load(), int64(5+len(given)); got != want {
+		t.Errorf("expected count %d, got %d", want, got)
+	}
+}
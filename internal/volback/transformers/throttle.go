@@ -0,0 +1,81 @@
+package transformers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// TimeWindow is a span of every day, given as how long after midnight it
+// starts and ends. It wraps past midnight if End is before Start.
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Contains reports whether t's time of day falls in the window
+func (w TimeWindow) Contains(t time.Time) bool {
+	h, m, s := t.Clock()
+	offset := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// ThrottleTransformer copies its input to its output no faster than Limiter
+// allows. If Window is set, the limit only applies during it.
+type ThrottleTransformer struct {
+	Limiter *rate.Limiter
+	Window  *TimeWindow
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+// NewThrottleTransformer limits throughput to bytesPerSec, allowing bursts
+// of up to burst bytes. burst defaults to a second's worth.
+func NewThrottleTransformer(bytesPerSec, burst int) *ThrottleTransformer {
+	if burst <= 0 {
+		burst = bytesPerSec
+	}
+	return &ThrottleTransformer{Limiter: rate.NewLimiter(rate.Limit(bytesPerSec), burst)}
+}
+
+func (tf *ThrottleTransformer) Transform(ctx context.Context, input io.Reader, output io.Writer) error {
+	// A single wait can't be for more than the burst
+	buf := make([]byte, min(tf.Limiter.Burst(), 32*1024))
+	for {
+		n, err := input.Read(buf)
+		if n > 0 {
+			if tf.limited() {
+				if werr := tf.Limiter.WaitN(ctx, n); werr != nil {
+					return fmt.Errorf("waiting to write %d bytes: %w", n, werr)
+				}
+			}
+			if _, werr := output.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading: %w", err)
+		}
+	}
+}
+
+// limited reports whether the limit currently applies
+func (tf *ThrottleTransformer) limited() bool {
+	if tf.Window == nil {
+		return true
+	}
+	now := time.Now
+	if tf.Now != nil {
+		now = tf.Now
+	}
+	return tf.Window.Contains(now())
+}
@@ -0,0 +1,87 @@
+package transformers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeWindow_Contains(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	testCases := []struct {
+		name   string
+		window TimeWindow
+		at     time.Duration
+		want   bool
+	}{
+		{name: "inside", window: TimeWindow{9 * time.Hour, 17 * time.Hour}, at: 12 * time.Hour, want: true},
+		{name: "at start", window: TimeWindow{9 * time.Hour, 17 * time.Hour}, at: 9 * time.Hour, want: true},
+		{name: "at end", window: TimeWindow{9 * time.Hour, 17 * time.Hour}, at: 17 * time.Hour, want: false},
+		{name: "before", window: TimeWindow{9 * time.Hour, 17 * time.Hour}, at: 8 * time.Hour, want: false},
+		{name: "wrapping late", window: TimeWindow{22 * time.Hour, 6 * time.Hour}, at: 23 * time.Hour, want: true},
+		{name: "wrapping early", window: TimeWindow{22 * time.Hour, 6 * time.Hour}, at: 5 * time.Hour, want: true},
+		{name: "wrapping outside", window: TimeWindow{22 * time.Hour, 6 * time.Hour}, at: 12 * time.Hour, want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.window.Contains(day.Add(tc.at)); got != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestThrottleTransformer(t *testing.T) {
+	given := bytes.Repeat([]byte("x"), 3000)
+
+	testCases := []struct {
+		name      string
+		window    *TimeWindow
+		throttled bool
+	}{
+		{name: "always", throttled: true},
+		{name: "inside window", window: &TimeWindow{Start: 0, End: 12 * time.Hour}, throttled: true},
+		{name: "outside window", window: &TimeWindow{Start: 12 * time.Hour, End: 13 * time.Hour}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The burst covers the first 1000 bytes, the rest take 200ms
+			tf := NewThrottleTransformer(10000, 1000)
+			tf.Window = tc.window
+			tf.Now = func() time.Time { return time.Date(2024, 3, 1, 9, 0, 0, 0, time.Local) }
+
+			var out bytes.Buffer
+			start := time.Now()
+			if err := tf.Transform(t.Context(), bytes.NewReader(given), &out); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			elapsed := time.Since(start)
+
+			if !bytes.Equal(given, out.Bytes()) {
+				t.Errorf("expected the input to be copied as is")
+			}
+			if tc.throttled && elapsed < 150*time.Millisecond {
+				t.Errorf("expected the copy to be throttled, took %s", elapsed)
+			}
+			if !tc.throttled && elapsed > 100*time.Millisecond {
+				t.Errorf("expected the copy not to be throttled, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestThrottleTransformer_Cancel(t *testing.T) {
+	tf := NewThrottleTransformer(100, 100)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	err := tf.Transform(ctx, strings.NewReader("more than a burst's worth of bytes, which would wait a second"), &bytes.Buffer{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+}
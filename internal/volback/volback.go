@@ -4,96 +4,428 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/jacobmiller22/volume-backup/internal/config"
+	"github.com/jacobmiller22/volume-backup/internal/hooks"
+	"github.com/jacobmiller22/volume-backup/internal/progress"
+	"github.com/jacobmiller22/volume-backup/internal/snapshot"
 	"github.com/jacobmiller22/volume-backup/internal/volback/transformers"
 
 	"github.com/jacobmiller22/volume-backup/internal/crypto"
 	"github.com/jacobmiller22/volume-backup/internal/pipes"
 )
 
-func NewExecutorFromConfig(cfg *config.Config) (*volbackExecutor, error) {
+// FormatVersion identifies how volback lays out the objects it pushes
+const FormatVersion = "1"
+
+// Metadata keys describing a backup, recorded by backends that can store
+// metadata alongside an object
+const (
+	SourceHostMetadataKey    = "volback-source-host"
+	SourcePathMetadataKey    = "volback-source-path"
+	FormatVersionMetadataKey = "volback-format-version"
+)
+
+func NewExecutorFromConfig(ctx context.Context, cfg *config.Config) (*volbackExecutor, error) {
 
 	var errs []error
-	puller, err := pullerFromConfig(cfg)
-	errs = append(errs, err)
-	pusher, err := pusherFromConfig(cfg)
+	puller, err := pullerFromConfig(ctx, cfg)
 	errs = append(errs, err)
 
+	dsts := cfg.AllDestinations()
+	pushers := make([]Pusher, len(dsts))
+	for i := range dsts {
+		pushers[i], err = pusherFromConfig(ctx, cfg, &dsts[i])
+		errs = append(errs, err)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return NewExecutor(cfg, puller, pushers...)
+}
+
+// NewExecutor creates an executor that pulls from puller and pushes to pushers
+// instead of the kinds named in cfg, which configures everything else. There
+// must be one pusher for each of cfg's destinations, in the same order.
+func NewExecutor(cfg *config.Config, puller Puller, pushers ...Pusher) (*volbackExecutor, error) {
+
+	locs := cfg.AllDestinations()
+	if len(pushers) != len(locs) {
+		return nil, fmt.Errorf("got %d pushers for %d destinations", len(pushers), len(locs))
+	}
+	dsts := make([]destination, len(locs))
+	for i, loc := range locs {
+		pipeline, err := throttlePipeline(&loc)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up throttle for %s %s: %w", loc.Kind, loc.Path, err)
+		}
+		dsts[i] = destination{kind: loc.Kind, path: loc.Path, pusher: pushers[i], retry: retryPolicyFromConfig(&loc), pipeline: pipeline}
+	}
+
 	encryptor, err := crypto.NewStreamEncryptor(cfg.Encryption.Key)
-	errs = append(errs, err)
 	if err != nil {
 		panic("unhandled error setting up stream encryptor")
 	}
 
 	decryptor, err := crypto.NewStreamDecryptor(cfg.Encryption.Key)
-	errs = append(errs, err)
 	if err != nil {
 		panic("unhandled error setting up stream decryptor")
 	}
 
-	if err := errors.Join(errs...); err != nil {
-		return nil, err
-	}
-
+	meter := progress.NewMeter()
 	backupPipeline, err := pipes.NewIOPipeline([]pipes.IOPipe{
+		pipes.NewIOPipe("meter_read", (&transformers.MeterTransformer{Count: &meter.Read}).Transform),
 		pipes.NewIOPipe("encrypt", (&transformers.EncryptionTransformer{Encryptor: encryptor}).Transform),
+		pipes.NewIOPipe("meter_written", (&transformers.MeterTransformer{Count: &meter.Written}).Transform),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error setting up backup pipeline: %w", err)
 	}
 	restorePipeline, err := pipes.NewIOPipeline([]pipes.IOPipe{
+		pipes.NewIOPipe("meter_read", (&transformers.MeterTransformer{Count: &meter.Read}).Transform),
 		pipes.NewIOPipe("decrypt", (&transformers.DecryptionTransformer{Decryptor: decryptor}).Transform),
+		pipes.NewIOPipe("meter_written", (&transformers.MeterTransformer{Count: &meter.Written}).Transform),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error setting up restore pipeline: %w", err)
 	}
 
+	// A missing hostname isn't worth failing the backup over
+	hostname, _ := os.Hostname()
+
+	progressFormat := cfg.Progress
+	if progressFormat == "" {
+		progressFormat = progress.FormatText
+	}
+	progressInterval := defaultProgressInterval
+	if cfg.ProgressInterval != "" {
+		progressInterval, _ = time.ParseDuration(cfg.ProgressInterval)
+	}
+
+	// Only fs sources are archived, so only they have snapshot settings
+	fsOpts := &FsOptions{}
+	if cfg.Source.Kind == "fs" {
+		if fsOpts, err = decodeOptions[FsOptions](backendLocation(cfg, &cfg.Source)); err != nil {
+			return nil, fmt.Errorf("error reading fs source options: %w", err)
+		}
+	}
+
 	return &volbackExecutor{
-		srcPath: cfg.Source.Path,
-		puller:  puller,
+		srcKind:  cfg.Source.Kind,
+		srcPath:  cfg.Source.Path,
+		puller:   puller,
+		srcRetry: retryPolicyFromConfig(&cfg.Source),
+		snapshot: snapshot.Options{
+			Mode:           fsOpts.Snapshot,
+			Command:        fsOpts.SnapshotCommand,
+			CleanupCommand: fsOpts.SnapshotCleanupCommand,
+			Dir:            fsOpts.SnapshotDir,
+			OneFileSystem:  fsOpts.OneFileSystem,
+			SkipUnreadable: fsOpts.OnReadError == "skip",
+			AllowCopy:      fsOpts.SnapshotAllowCopy,
+		},
 
-		dstPath: cfg.Destination.Path,
-		pusher:  pusher,
+		destinations:      dsts,
+		destinationPolicy: cfg.DestinationPolicy,
 
+		hooks: cfg.Hooks,
+
+		backupMetadata: map[string]string{
+			SourceHostMetadataKey:    hostname,
+			SourcePathMetadataKey:    cfg.Source.Path,
+			FormatVersionMetadataKey: FormatVersion,
+		},
 		backupPipeline:  backupPipeline,
 		restorePipeline: restorePipeline,
+
+		meter:            meter,
+		progressFormat:   progressFormat,
+		progressInterval: progressInterval,
+		progressOut:      os.Stderr,
 	}, nil
 }
 
 type volbackExecutor struct {
-	srcPath string
-	puller  Puller
+	srcKind  string
+	srcPath  string
+	puller   Puller
+	srcRetry RetryPolicy
+	snapshot snapshot.Options
+
+	destinations      []destination
+	destinationPolicy string
 
-	dstPath string
-	pusher  Pusher
+	hooks config.Hooks
 
+	// backupMetadata is pushed alongside backups
+	backupMetadata  map[string]string
 	backupPipeline  *pipes.IOPipeline
 	restorePipeline *pipes.IOPipeline
+
+	// meter is updated by both pipelines, and reported to progressOut while
+	// they run
+	meter            *progress.Meter
+	progressFormat   string
+	progressInterval time.Duration
+	progressOut      io.Writer
 }
 
-func process(ctx context.Context, puller Puller, srcPath string, pl *pipes.IOPipeline, pusher Pusher, dstPath string) error {
-	initialReader, err := puller.Pull(srcPath)
+// Result describes a completed backup or restore. A run that failed because
+// its destinations did still returns one, describing how each of them fared.
+type Result struct {
+	// Warnings are problems that didn't fail the run, such as files skipped
+	// because they couldn't be read
+	Warnings []error
+
+	// Destinations reports the outcome of pushing to each destination, in the
+	// order they were configured
+	Destinations []DestinationResult
+}
+
+// DestinationResult is the outcome of pushing to a single destination
+type DestinationResult struct {
+	Kind string
+	Path string
+	// Err is nil if the push succeeded
+	Err error
+}
+
+// warner is implemented by readers that can report non-fatal problems once
+// they have been fully consumed
+type warner interface {
+	Warnings() []error
+}
+
+// archiver is implemented by readers that archive files as they are read,
+// and can report how many files and bytes of them they have archived
+type archiver interface {
+	Progress() (files, bytes int64)
+}
+
+// defaultProgressInterval is how often progress is reported unless
+// configured otherwise
+const defaultProgressInterval = 10 * time.Second
+
+// push pushes r to path, along with metadata if p can store it
+func push(ctx context.Context, p Pusher, r io.Reader, path string, metadata map[string]string) error {
+	if mp, ok := p.(MetadataPusher); ok && len(metadata) > 0 {
+		return mp.PushWithMetadata(ctx, r, path, metadata)
+	}
+	return p.Push(ctx, r, path)
+}
+
+// throttlePipeline limits the bandwidth pushes to loc use, or is nil if loc
+// has no limit. In a fan-out the tee can only run a buffer ahead of the
+// slowest destination, so its limit paces the others too.
+func throttlePipeline(loc *config.Location) (*pipes.IOPipeline, error) {
+	if loc.BandwidthLimit == 0 {
+		return nil, nil
+	}
+	throttle := transformers.NewThrottleTransformer(loc.BandwidthLimit, loc.BandwidthBurst)
+	if loc.BandwidthWindow != "" {
+		start, end, err := config.ParseTimeWindow(loc.BandwidthWindow)
+		if err != nil {
+			return nil, err
+		}
+		throttle.Window = &transformers.TimeWindow{Start: start, End: end}
+	}
+	return pipes.NewIOPipeline([]pipes.IOPipe{
+		pipes.NewIOPipe("throttle", throttle.Transform),
+	})
+}
+
+// measureSource sets meter's total to how many bytes will be read from path,
+// if puller can tell. It walks the whole source for some pullers, so it is
+// meant to run alongside the pull.
+func measureSource(ctx context.Context, meter *progress.Meter, puller Puller, path string) {
+	switch p := puller.(type) {
+	case Sizer:
+		if size, err := p.Size(ctx, path); err == nil {
+			meter.SetTotal(size)
+		}
+	case Stater:
+		if info, err := p.Stat(ctx, path); err == nil {
+			meter.SetTotal(info.Size)
+		}
+	}
+}
+
+func process(ctx context.Context, puller Puller, srcRetry RetryPolicy, srcPath string, pl *pipes.IOPipeline, meter *progress.Meter, dsts []destination, policy string, metadata map[string]string) (*Result, error) {
+	initialReader, err := retryPull(ctx, srcRetry, puller, srcPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	if a, ok := initialReader.(archiver); ok {
+		meter.TrackArchive(a.Progress)
+	}
+	measureCtx, stopMeasuring := context.WithCancel(ctx)
+	defer stopMeasuring()
+	go measureSource(measureCtx, meter, puller, srcPath)
+
 	r := pl.Execute(ctx, initialReader)
 
-	if err := pusher.Push(r, dstPath); err != nil {
-		return fmt.Errorf("error while pushing: %w", err)
+	var results []DestinationResult
+	if len(dsts) == 1 {
+		// No need to tee a single destination
+		d := dsts[0]
+		// Stop the pipeline if the pusher returns without reading everything
+		if c, ok := r.(io.Closer); ok {
+			defer c.Close()
+		}
+		results = []DestinationResult{{Kind: d.kind, Path: d.path, Err: d.push(ctx, r, metadata)}}
+	} else {
+		results, err = fanOut(ctx, r, dsts, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("error while pushing: %w", err)
+		}
+	}
+
+	result := &Result{Destinations: results}
+	if w, ok := initialReader.(warner); ok {
+		result.Warnings = w.Warnings()
+	}
+
+	var failed []error
+	for _, res := range results {
+		if res.Err != nil {
+			log.Printf("Failed to push to %s %s: %v\n", res.Kind, res.Path, res.Err)
+			failed = append(failed, fmt.Errorf("%s %s: %w", res.Kind, res.Path, res.Err))
+		}
+	}
+	if len(failed) > 0 && (policy != "any" || len(failed) == len(results)) {
+		// The result still tells which destinations got the backup
+		return result, fmt.Errorf("error while pushing: %w", errors.Join(failed...))
+	}
+
+	return result, nil
+}
+
+// Backup archives the source and pushes it to every destination. Cancelling
+// ctx stops the backup, and uploads in progress are aborted rather than left
+// behind half written.
+func (e *volbackExecutor) Backup(ctx context.Context) (*Result, error) {
+	log.Printf("Backing up %s to %s\n", e.srcPath, e.dstPaths())
+	return e.withHooks(ctx, "backup", e.hooks.PreBackup, e.hooks.PostBackup, e.backup)
+}
+
+// Restore pulls the backup at the source and unpacks it to every
+// destination. Cancelling ctx stops the restore.
+func (e *volbackExecutor) Restore(ctx context.Context) (*Result, error) {
+	log.Printf("Restoring from %s to %s\n", e.srcPath, e.dstPaths())
+	return e.withHooks(ctx, "restore", e.hooks.PreRestore, e.hooks.PostRestore, func(ctx context.Context) (*Result, error) {
+		defer e.reportProgress(ctx, "restore")()
+		return process(ctx, e.puller, e.srcRetry, e.srcPath, e.restorePipeline, e.meter, e.destinations, e.destinationPolicy, nil)
+	})
+}
+
+func (e *volbackExecutor) backup(ctx context.Context) (result *Result, err error) {
+	srcPath := e.srcPath
+	if e.snapshot.Mode != "" {
+		snap, err := snapshot.Create(ctx, e.srcPath, e.snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("error creating snapshot: %w", err)
+		}
+		defer func() {
+			// The backup itself is intact, so a failed cleanup only warrants a
+			// warning. It is cleaned up even if the backup was cancelled.
+			if cerr := snap.Cleanup(context.WithoutCancel(ctx)); cerr != nil {
+				log.Printf("Failed to clean up snapshot %s: %v\n", snap.Path, cerr)
+				if result != nil {
+					result.Warnings = append(result.Warnings, cerr)
+				}
+			}
+		}()
+		log.Printf("Archiving from snapshot %s\n", snap.Path)
+		srcPath = snap.Path
+		defer func() {
+			if result != nil {
+				result.Warnings = append(snap.Warnings, result.Warnings...)
+			}
+		}()
 	}
 
-	return nil
+	defer e.reportProgress(ctx, "backup")()
+	return process(ctx, e.puller, e.srcRetry, srcPath, e.backupPipeline, e.meter, e.destinations, e.destinationPolicy, e.backupMetadata)
 }
 
-func (e *volbackExecutor) Backup() error {
-	log.Printf("Backing up %s to %s\n", e.srcPath, e.dstPath)
-	return process(context.TODO(), e.puller, e.srcPath, e.backupPipeline, e.pusher, e.dstPath)
+// reportProgress starts the meter over and reports it until the returned
+// func is called
+func (e *volbackExecutor) reportProgress(ctx context.Context, operation string) (stop func()) {
+	e.meter.Reset()
+	return progress.Report(ctx, e.progressOut, e.progressFormat, e.progressInterval, operation, e.meter)
 }
 
-func (e *volbackExecutor) Restore() error {
-	log.Printf("Restoring from %s to %s\n", e.srcPath, e.dstPath)
-	return process(context.TODO(), e.puller, e.srcPath, e.restorePipeline, e.pusher, e.dstPath)
+// dstPaths describes every destination for logging
+func (e *volbackExecutor) dstPaths() string {
+	paths := make([]string, len(e.destinations))
+	for i, d := range e.destinations {
+		paths[i] = d.path
+	}
+	return strings.Join(paths, ", ")
+}
+
+// withHooks runs fn between the pre and post hooks for operation. A failing
+// pre hook aborts the run before fn is called. Post hooks always run, with
+// VOLBACK_STATUS set to success, failure, or aborted if fn never ran, and the
+// on failure hooks run last if anything failed. They run even if ctx was
+// cancelled, since they typically undo what the pre hooks did. A post hook
+// failing after fn succeeded only adds a warning to its result.
+func (e *volbackExecutor) withHooks(ctx context.Context, operation string, pre, post []config.Hook, fn func(context.Context) (*Result, error)) (*Result, error) {
+	env := []string{
+		"VOLBACK_OPERATION=" + operation,
+		"VOLBACK_SRC_KIND=" + e.srcKind,
+		"VOLBACK_SRC_PATH=" + e.srcPath,
+	}
+	// Multiple destinations are comma separated, in the order they were
+	// configured
+	kinds := make([]string, len(e.destinations))
+	paths := make([]string, len(e.destinations))
+	for i, d := range e.destinations {
+		kinds[i], paths[i] = d.kind, d.path
+	}
+	env = append(env,
+		"VOLBACK_DST_KIND="+strings.Join(kinds, ","),
+		"VOLBACK_DST_PATH="+strings.Join(paths, ","),
+	)
+
+	var result *Result
+	status := "success"
+	err := hooks.Run(ctx, "pre_"+operation, pre, env)
+	if err != nil {
+		status = "aborted"
+	} else if result, err = fn(ctx); err != nil {
+		status = "failure"
+	}
+
+	// Whatever the pre hooks stopped still has to be started again
+	ctx = context.WithoutCancel(ctx)
+	env = append(env, "VOLBACK_STATUS="+status)
+
+	if perr := hooks.Run(ctx, "post_"+operation, post, env); perr != nil {
+		if err == nil {
+			// The backup or restore itself is intact
+			log.Printf("Post %s hook failed: %v\n", operation, perr)
+			result.Warnings = append(result.Warnings, perr)
+		} else {
+			err = errors.Join(err, perr)
+		}
+	}
+
+	if err != nil {
+		env = append(env, "VOLBACK_ERROR="+err.Error())
+		if ferr := hooks.Run(ctx, "on_failure", e.hooks.OnFailure, env); ferr != nil {
+			log.Printf("Failure hook failed: %v\n", ferr)
+		}
+		return result, err
+	}
+
+	return result, nil
 }
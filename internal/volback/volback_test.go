@@ -0,0 +1,539 @@
+package volback
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jacobmiller22/volume-backup/internal/config"
+	"github.com/jacobmiller22/volume-backup/internal/progress"
+	"github.com/jacobmiller22/volume-backup/internal/zip"
+)
+
+func newTestConfig() *config.Config {
+	cfg := &config.Config{
+		Source:      config.Location{Kind: "mem", Path: "source"},
+		Destination: config.Location{Kind: "mem", Path: "backup"},
+	}
+	cfg.Encryption.Key = "temp size 16 key"
+	return cfg
+}
+
+func TestExecutor_RoundTrip(t *testing.T) {
+	given := []byte("contents that make it through a backup and restore")
+
+	src := NewMemPushPuller()
+	src.Put("source", given)
+	backups := NewMemPushPuller()
+
+	cfg := newTestConfig()
+	backup, err := NewExecutor(cfg, src, backups)
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	if _, err := backup.Backup(t.Context()); err != nil {
+		t.Fatalf("unexpected error backing up: %v", err)
+	}
+
+	encrypted, ok := backups.Get("backup")
+	if !ok {
+		t.Fatalf("expected backup to be pushed")
+	}
+	if cmp.Equal(given, encrypted) {
+		t.Fatalf("expected backup to be encrypted")
+	}
+	info, _ := backups.Stat(t.Context(), "backup")
+	if info.Metadata[FormatVersionMetadataKey] != FormatVersion || info.Metadata[SourcePathMetadataKey] != "source" {
+		t.Errorf("expected metadata describing the backup, got: %v", info.Metadata)
+	}
+
+	restored := NewMemPushPuller()
+	cfg.Source.Path, cfg.Destination.Path = "backup", "restored"
+	restore, err := NewExecutor(cfg, backups, restored)
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	if _, err := restore.Restore(t.Context()); err != nil {
+		t.Fatalf("unexpected error restoring: %v", err)
+	}
+
+	got, _ := restored.Get("restored")
+	if diff := cmp.Diff(given, got); diff != "" {
+		t.Errorf("restored contents mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProcess_Faults(t *testing.T) {
+	given := []byte("contents that never make it to the destination")
+
+	testCases := []struct {
+		name      string
+		srcFaults MemFaults
+		dstFaults MemFaults
+	}{
+		{
+			name:      "pull error",
+			srcFaults: MemFaults{PullErr: ErrInjected, PullErrAfter: 10},
+		},
+		{
+			name:      "push error",
+			dstFaults: MemFaults{PushErr: ErrInjected, PushErrAfter: 10},
+		},
+		{
+			name:      "pull error before any bytes",
+			srcFaults: MemFaults{PullErr: ErrInjected},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := NewMemPushPuller()
+			src.Put("source", given)
+			src.SetFaults(tc.srcFaults)
+			dst := NewMemPushPuller()
+			dst.SetFaults(tc.dstFaults)
+
+			e, err := NewExecutor(newTestConfig(), src, dst)
+			if err != nil {
+				t.Fatalf("unexpected error setting up executor: %v", err)
+			}
+
+			_, err = process(t.Context(), src, RetryPolicy{}, "source", e.backupPipeline, e.meter, []destination{{kind: "mem", path: "backup", pusher: dst}}, "", nil)
+			if !errors.Is(err, ErrInjected) {
+				t.Fatalf("expected injected error, got: %v", err)
+			}
+			if _, ok := dst.Get("backup"); ok {
+				t.Errorf("expected nothing to be stored after a failure")
+			}
+		})
+	}
+}
+
+func TestExecutor_FanOut(t *testing.T) {
+	given := []byte("contents that are pushed to several destinations at once")
+
+	testCases := []struct {
+		name      string
+		policy    string
+		faults    MemFaults
+		expectErr bool
+	}{
+		{
+			name: "all succeed",
+		},
+		{
+			name:      "one fails with policy all",
+			policy:    "all",
+			faults:    MemFaults{PushErr: ErrInjected, PushErrAfter: 10},
+			expectErr: true,
+		},
+		{
+			name:   "one fails with policy any",
+			policy: "any",
+			faults: MemFaults{PushErr: ErrInjected},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := NewMemPushPuller()
+			src.Put("source", given)
+			first, second, third := NewMemPushPuller(), NewMemPushPuller(), NewMemPushPuller()
+			second.SetFaults(tc.faults)
+
+			cfg := newTestConfig()
+			cfg.Destinations = []config.Location{
+				{Kind: "mem", Path: "second"},
+				{Kind: "mem", Path: "third"},
+			}
+			cfg.DestinationPolicy = tc.policy
+
+			e, err := NewExecutor(cfg, src, first, second, third)
+			if err != nil {
+				t.Fatalf("unexpected error setting up executor: %v", err)
+			}
+			result, err := e.Backup(t.Context())
+			if tc.expectErr {
+				if !errors.Is(err, ErrInjected) {
+					t.Fatalf("expected injected error, got: %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error backing up: %v", err)
+			}
+
+			// The healthy destinations get the whole backup regardless
+			want, _ := first.Get("backup")
+			got, _ := third.Get("third")
+			if len(want) == 0 || !bytes.Equal(want, got) {
+				t.Errorf("expected every healthy destination to receive the same backup")
+			}
+
+			if result == nil {
+				t.Fatalf("expected a result describing every destination")
+			}
+			var failed []string
+			for _, res := range result.Destinations {
+				if res.Err != nil {
+					failed = append(failed, res.Path)
+				}
+			}
+			wantFailed := []string(nil)
+			if tc.faults.PushErr != nil {
+				wantFailed = []string{"second"}
+			}
+			if diff := cmp.Diff(wantFailed, failed); diff != "" {
+				t.Errorf("failed destinations mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFanOut_IncompletePush(t *testing.T) {
+	given := []byte("contents that one destination stops reading part way through")
+	full := NewMemPushPuller()
+	dsts := []destination{
+		{kind: "mem", path: "full", pusher: full},
+		{kind: "mem", path: "short", pusher: &shortPusher{NewMemPushPuller()}},
+	}
+
+	// shortPusher only truncates pushes with metadata
+	results, err := fanOut(t.Context(), bytes.NewReader(given), dsts, map[string]string{"owner": "volback"})
+	if err != nil {
+		t.Fatalf("unexpected error fanning out: %v", err)
+	}
+	if results[0].Err != nil {
+		t.Errorf("unexpected error pushing the whole backup: %v", results[0].Err)
+	}
+	if !errors.Is(results[1].Err, errIncompletePush) {
+		t.Errorf("expected the truncated push to fail, got: %v", results[1].Err)
+	}
+	if got, _ := full.Get("full"); !bytes.Equal(given, got) {
+		t.Errorf("expected the healthy destination to receive the whole backup, got %q", got)
+	}
+}
+
+func TestNewExecutor_PusherCount(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Destinations = []config.Location{{Kind: "mem", Path: "second"}}
+	if _, err := NewExecutor(cfg, NewMemPushPuller(), NewMemPushPuller()); err == nil {
+		t.Fatalf("expected an error for a missing pusher")
+	}
+}
+
+func TestExecutor_FailureHooks(t *testing.T) {
+	dir := t.TempDir()
+
+	src := NewMemPushPuller()
+	src.Put("source", []byte("contents"))
+	src.SetFaults(MemFaults{PullErr: ErrInjected, PullErrAfter: 4})
+
+	cfg := newTestConfig()
+	cfg.Hooks = config.Hooks{
+		PreBackup:  []config.Hook{{Command: "touch " + filepath.Join(dir, "pre")}},
+		PostBackup: []config.Hook{{Command: `echo "$VOLBACK_STATUS" > ` + filepath.Join(dir, "post")}},
+		OnFailure:  []config.Hook{{Command: "touch " + filepath.Join(dir, "failure")}},
+	}
+
+	e, err := NewExecutor(cfg, src, NewMemPushPuller())
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	if _, err := e.Backup(t.Context()); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected injected error, got: %v", err)
+	}
+
+	for _, marker := range []string{"pre", "post", "failure"} {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err != nil {
+			t.Errorf("expected %s hook to run: %v", marker, err)
+		}
+	}
+	status, _ := os.ReadFile(filepath.Join(dir, "post"))
+	if diff := cmp.Diff("failure\n", string(status)); diff != "" {
+		t.Errorf("post hook status mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecutor_HookFailures(t *testing.T) {
+	testCases := []struct {
+		name         string
+		pre          string
+		post         string
+		wantErr      bool
+		wantStatus   string
+		wantWarnings int
+	}{
+		{
+			name:       "pre hook fails",
+			pre:        "exit 1",
+			post:       "true",
+			wantErr:    true,
+			wantStatus: "aborted\n",
+		},
+		{
+			name:         "post hook fails after success",
+			pre:          "true",
+			post:         "exit 1",
+			wantStatus:   "success\n",
+			wantWarnings: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := NewMemPushPuller()
+			src.Put("source", []byte("contents"))
+			backups := NewMemPushPuller()
+
+			cfg := newTestConfig()
+			cfg.Hooks = config.Hooks{
+				PreBackup: []config.Hook{{Command: tc.pre}},
+				PostBackup: []config.Hook{
+					{Command: `echo "$VOLBACK_STATUS" > ` + filepath.Join(dir, "post"), ContinueOnError: true},
+					{Command: tc.post},
+				},
+				OnFailure: []config.Hook{{Command: "touch " + filepath.Join(dir, "failure")}},
+			}
+
+			e, err := NewExecutor(cfg, src, backups)
+			if err != nil {
+				t.Fatalf("unexpected error setting up executor: %v", err)
+			}
+			result, err := e.Backup(t.Context())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got: %v", tc.wantErr, err)
+			}
+
+			status, _ := os.ReadFile(filepath.Join(dir, "post"))
+			if diff := cmp.Diff(tc.wantStatus, string(status)); diff != "" {
+				t.Errorf("post hook status mismatch (-want +got):\n%s", diff)
+			}
+			_, ferr := os.Stat(filepath.Join(dir, "failure"))
+			if ran := ferr == nil; ran != tc.wantErr {
+				t.Errorf("expected the failure hook to run: %v, ran: %v", tc.wantErr, ran)
+			}
+			if tc.wantErr {
+				return
+			}
+			if got := len(result.Warnings); got != tc.wantWarnings {
+				t.Errorf("got %d warnings, want %d: %v", got, tc.wantWarnings, result.Warnings)
+			}
+			if _, ok := backups.Get("backup"); !ok {
+				t.Errorf("expected the backup to be kept")
+			}
+		})
+	}
+}
+
+// cancelingPuller pulls a source that cancels the run part way through
+type cancelingPuller struct {
+	cancel context.CancelFunc
+}
+
+func (p *cancelingPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	return &cancelingReader{r: strings.NewReader("contents"), n: 4, ctx: ctx, cancel: p.cancel}, nil
+}
+
+func TestExecutor_Cancel(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := newTestConfig()
+	cfg.Hooks = config.Hooks{
+		PostBackup: []config.Hook{{Command: `echo "$VOLBACK_STATUS" > ` + filepath.Join(dir, "post")}},
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	dst := NewMemPushPuller()
+	e, err := NewExecutor(cfg, &cancelingPuller{cancel: cancel}, dst)
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	if _, err := e.Backup(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the backup to be cancelled, got: %v", err)
+	}
+
+	if _, ok := dst.Get("backup"); ok {
+		t.Errorf("expected nothing to be pushed")
+	}
+	status, err := os.ReadFile(filepath.Join(dir, "post"))
+	if err != nil {
+		t.Fatalf("expected the post hook to run after cancelling: %v", err)
+	}
+	if diff := cmp.Diff("failure\n", string(status)); diff != "" {
+		t.Errorf("post hook status mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecutor_Throttle(t *testing.T) {
+	given := bytes.Repeat([]byte("contents pushed to a throttled destination "), 100)
+
+	for _, faults := range []MemFaults{{}, {PushErr: ErrInjected}} {
+		src := NewMemPushPuller()
+		src.Put("source", given)
+		first, second := NewMemPushPuller(), NewMemPushPuller()
+		second.SetFaults(faults)
+
+		cfg := newTestConfig()
+		cfg.Destinations = []config.Location{{
+			Kind:             "mem",
+			Path:             "second",
+			ThrottleLocation: config.ThrottleLocation{BandwidthLimit: 1 << 20, BandwidthWindow: "00:00-23:59"},
+		}}
+		cfg.DestinationPolicy = "any"
+
+		e, err := NewExecutor(cfg, src, first, second)
+		if err != nil {
+			t.Fatalf("unexpected error setting up executor: %v", err)
+		}
+		if _, err := e.Backup(t.Context()); err != nil {
+			t.Fatalf("unexpected error backing up: %v", err)
+		}
+
+		want, _ := first.Get("backup")
+		got, ok := second.Get("second")
+		if faults.PushErr != nil {
+			if ok {
+				t.Errorf("expected nothing to be stored by the failing destination")
+			}
+			continue
+		}
+		if len(want) == 0 || !bytes.Equal(want, got) {
+			t.Errorf("expected the throttled destination to receive the same backup")
+		}
+	}
+}
+
+// timedPusher records when each push finished
+type timedPusher struct {
+	*MemPushPuller
+	done time.Time
+}
+
+func (p *timedPusher) PushWithMetadata(ctx context.Context, r io.Reader, path string, metadata map[string]string) error {
+	err := p.MemPushPuller.PushWithMetadata(ctx, r, path, metadata)
+	p.done = time.Now()
+	return err
+}
+
+func TestExecutor_ThrottlePacesFanOut(t *testing.T) {
+	// The burst covers the first 1000 bytes, the rest take at least 280ms
+	given := bytes.Repeat([]byte("x"), 15000)
+	src := NewMemPushPuller()
+	src.Put("source", given)
+	first, second := &timedPusher{MemPushPuller: NewMemPushPuller()}, &timedPusher{MemPushPuller: NewMemPushPuller()}
+
+	cfg := newTestConfig()
+	cfg.Destinations = []config.Location{{
+		Kind:             "mem",
+		Path:             "second",
+		ThrottleLocation: config.ThrottleLocation{BandwidthLimit: 50000, BandwidthBurst: 1000},
+	}}
+	e, err := NewExecutor(cfg, src, first, second)
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	e.progressFormat = progress.FormatQuiet
+
+	start := time.Now()
+	if _, err := e.Backup(t.Context()); err != nil {
+		t.Fatalf("unexpected error backing up: %v", err)
+	}
+
+	// Both destinations are fed from the same tee, so the unthrottled one
+	// can only get a buffer or so ahead of the throttled one
+	if elapsed := second.done.Sub(start); elapsed < 250*time.Millisecond {
+		t.Errorf("expected the throttled destination to take at least 250ms, took %s", elapsed)
+	}
+	if elapsed := first.done.Sub(start); elapsed < 200*time.Millisecond {
+		t.Errorf("expected the unthrottled destination to be paced by the throttled one, took %s", elapsed)
+	}
+}
+
+func TestExecutor_Progress(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("contents of "+name), 0o644); err != nil {
+			t.Fatalf("unexpected error writing file: %v", err)
+		}
+	}
+
+	cfg := newTestConfig()
+	cfg.Source = config.Location{Kind: "fs", Path: dir}
+	cfg.Progress = "json"
+	e, err := NewExecutor(cfg, &FsPushPuller{}, NewMemPushPuller())
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	var out bytes.Buffer
+	e.progressOut = &out
+
+	if _, err := e.Backup(t.Context()); err != nil {
+		t.Fatalf("unexpected error backing up: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var final struct {
+		Final        bool  `json:"final"`
+		Files        int64 `json:"files"`
+		BytesRead    int64 `json:"bytes_read"`
+		BytesWritten int64 `json:"bytes_written"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &final); err != nil {
+		t.Fatalf("expected a json report, got %q: %v", out.String(), err)
+	}
+	if !final.Final || final.Files != 3 {
+		t.Errorf("expected a final report of 3 files, got %+v", final)
+	}
+	if final.BytesRead == 0 || final.BytesWritten <= final.BytesRead {
+		t.Errorf("expected the encrypted archive written to outweigh the archive read, got %+v", final)
+	}
+}
+
+func TestExecutor_ReadErrorWarnings(t *testing.T) {
+	// Reading /proc/self/mem from offset 0 fails with EIO on Linux, which
+	// provokes a read error even as root
+	const path = "/proc/self/mem"
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		t.Skipf("%s isn't available to provoke a read error", path)
+	}
+
+	cfg := newTestConfig()
+	cfg.Source = config.Location{Kind: "fs", Path: path, Options: map[string]string{"on_read_error": "skip"}}
+	cfg.Progress = progress.FormatQuiet
+	backups := NewMemPushPuller()
+	backup, err := NewExecutor(cfg, &FsPushPuller{archiveOpts: zip.ArchiveOptions{ReadErrors: zip.ReadErrorSkip}}, backups)
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	result, err := backup.Backup(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error backing up: %v", err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Error(), path) {
+		t.Fatalf("expected a warning about %s, got: %v", path, result.Warnings)
+	}
+
+	restorePath := filepath.Join(t.TempDir(), "restore")
+	cfg.Source = config.Location{Kind: "mem", Path: "backup"}
+	cfg.Destination = config.Location{Kind: "fs", Path: restorePath}
+	cfg.Restore = true
+	restore, err := NewExecutor(cfg, backups, &FsPushPuller{restore: true})
+	if err != nil {
+		t.Fatalf("unexpected error setting up executor: %v", err)
+	}
+	if _, err := restore.Restore(t.Context()); err != nil {
+		t.Fatalf("unexpected error restoring: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(restorePath, "mem")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the truncated file not to be restored, got: %v", err)
+	}
+}
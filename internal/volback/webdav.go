@@ -0,0 +1,239 @@
+package volback
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jacobmiller22/volume-backup/backend"
+)
+
+func init() {
+	backend.Register("webdav", backend.Backend{
+		NewPuller: func(_ context.Context, loc *backend.Location) (Puller, error) {
+			return newWebdavPushPullerFromLocation(loc)
+		},
+		NewPusher: func(_ context.Context, loc *backend.Location) (Pusher, error) {
+			return newWebdavPushPullerFromLocation(loc)
+		},
+		Options: &WebdavOptions{},
+		Validate: func(loc *backend.Location) error {
+			opts, err := decodeOptions[WebdavOptions](loc)
+			if err != nil {
+				return err
+			}
+			if opts.Webdav_Url == "" {
+				return fmt.Errorf("webdav_url is required")
+			}
+			if opts.Webdav_ChunkSize > 0 && opts.Webdav_ChunkUploadsUrl == "" {
+				return fmt.Errorf("webdav_chunk_uploads_url is required when webdav_chunk_size is set")
+			}
+			return nil
+		},
+	})
+}
+
+// WebdavOptions configure a webdav location
+type WebdavOptions struct {
+	Webdav_Url         string `json:"webdav_url" usage:"Base url of the WebDAV collection"`
+	Webdav_User        string `json:"webdav_user" usage:"User for WebDAV basic auth"`
+	Webdav_Password    string `json:"webdav_password" usage:"Password for WebDAV basic auth"`
+	Webdav_BearerToken string `json:"webdav_bearer_token" usage:"Bearer token for WebDAV auth"`
+
+	// Uploads larger than this are sent in chunks to Webdav_ChunkUploadsUrl,
+	// e.g. https://cloud.example.com/remote.php/dav/uploads/<user>
+	Webdav_ChunkSize       int    `json:"webdav_chunk_size" usage:"Upload in chunks of this many bytes, 0 streams a single PUT"`
+	Webdav_ChunkUploadsUrl string `json:"webdav_chunk_uploads_url" usage:"Nextcloud style uploads collection that chunks are assembled in"`
+}
+
+func newWebdavPushPullerFromLocation(loc *backend.Location) (*WebdavPushPuller, error) {
+	opts, err := decodeOptions[WebdavOptions](loc)
+	if err != nil {
+		return nil, err
+	}
+	return newWebdavPushPuller(opts, loc.Retry)
+}
+
+// newWebdavPushPuller creates a WebdavPushPuller that retries each chunk it
+// uploads with retry
+func newWebdavPushPuller(opts *WebdavOptions, retry RetryPolicy) (*WebdavPushPuller, error) {
+	baseURL, err := url.Parse(opts.Webdav_Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webdav url: %w", err)
+	}
+
+	p := &WebdavPushPuller{
+		client:      &http.Client{Transport: newHTTPTransport(nil)},
+		baseURL:     baseURL,
+		user:        opts.Webdav_User,
+		password:    opts.Webdav_Password,
+		bearerToken: opts.Webdav_BearerToken,
+		chunkSize:   opts.Webdav_ChunkSize,
+		retry:       retry,
+	}
+
+	if opts.Webdav_ChunkUploadsUrl != "" {
+		if p.uploadsURL, err = url.Parse(opts.Webdav_ChunkUploadsUrl); err != nil {
+			return nil, fmt.Errorf("invalid webdav chunk uploads url: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// WebdavPushPuller stores backups in a WebDAV collection. Large uploads can be
+// split into chunks using Nextcloud's chunked upload protocol.
+type WebdavPushPuller struct {
+	client  *http.Client
+	baseURL *url.URL
+
+	user        string
+	password    string
+	bearerToken string
+
+	chunkSize  int
+	uploadsURL *url.URL
+	// retry retries each chunk of a chunked upload on its own, since the
+	// stream it was read from can't be rewound to retry the whole push
+	retry RetryPolicy
+}
+
+func (p *WebdavPushPuller) do(ctx context.Context, method string, u *url.URL, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if p.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.bearerToken)
+	} else if p.user != "" {
+		req.SetBasicAuth(p.user, p.password)
+	}
+
+	return p.client.Do(req)
+}
+
+func (p *WebdavPushPuller) Pull(ctx context.Context, path string) (io.Reader, error) {
+	resp, err := p.do(ctx, http.MethodGet, p.baseURL.JoinPath(path), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s over webdav: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, expectStatus(resp, http.StatusOK)
+	}
+	return resp.Body, nil
+}
+
+func (p *WebdavPushPuller) Push(ctx context.Context, r io.Reader, path string) error {
+	if err := p.mkcolParents(ctx, p.baseURL, path); err != nil {
+		return err
+	}
+
+	dst := p.baseURL.JoinPath(path)
+	if p.chunkSize > 0 {
+		return p.pushChunked(ctx, r, dst)
+	}
+
+	// The body has no known length, so this is sent with chunked transfer
+	// encoding rather than buffered
+	resp, err := p.do(ctx, http.MethodPut, dst, r, nil)
+	if err != nil {
+		return fmt.Errorf("failed to put %s over webdav: %w", path, err)
+	}
+	return expectStatus(resp, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+// mkcolParents creates every collection leading up to path below base
+func (p *WebdavPushPuller) mkcolParents(ctx context.Context, base *url.URL, path string) error {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	u := base
+	for _, segment := range segments[:len(segments)-1] {
+		u = u.JoinPath(segment)
+		resp, err := p.do(ctx, "MKCOL", u, nil, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create collection %s: %w", u.Redacted(), err)
+		}
+		// 405 means the collection already exists
+		if err := expectStatus(resp, http.StatusCreated, http.StatusMethodNotAllowed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushChunked uploads r to dst using Nextcloud's chunked upload protocol: the
+// chunks are PUT into a temporary upload collection, then assembled by moving
+// the collection's virtual .file onto the destination
+func (p *WebdavPushPuller) pushChunked(ctx context.Context, r io.Reader, dst *url.URL) (err error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	uploadDir := p.uploadsURL.JoinPath("volback-" + hex.EncodeToString(id))
+	header := http.Header{"Destination": {dst.String()}}
+
+	resp, err := p.do(ctx, "MKCOL", uploadDir, nil, header)
+	if err != nil {
+		return fmt.Errorf("failed to create upload collection: %w", err)
+	}
+	if err := expectStatus(resp, http.StatusCreated); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Don't leave partial chunks behind on the server, even if the push
+		// was cancelled
+		if resp, derr := p.do(context.WithoutCancel(ctx), http.MethodDelete, uploadDir, nil, nil); derr == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	buf := make([]byte, p.chunkSize)
+	var total int64
+	for chunk := 1; ; chunk++ {
+		n, rerr := io.ReadFull(r, buf)
+		if rerr != nil && !errors.Is(rerr, io.EOF) && !errors.Is(rerr, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("reading chunk %d: %w", chunk, rerr)
+		}
+
+		if n > 0 {
+			total += int64(n)
+			chunkURL := uploadDir.JoinPath(fmt.Sprintf("%05d", chunk))
+			err := retryDo(ctx, p.retry, fmt.Sprintf("put of chunk %d of %s", chunk, dst.Redacted()), func(ctx context.Context) error {
+				resp, err := p.do(ctx, http.MethodPut, chunkURL, bytes.NewReader(buf[:n]), header)
+				if err != nil {
+					return fmt.Errorf("failed to put chunk %d: %w", chunk, err)
+				}
+				return expectStatus(resp, http.StatusCreated, http.StatusNoContent)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// A short read means r is exhausted
+		if rerr != nil {
+			break
+		}
+	}
+
+	header.Set("OC-Total-Length", fmt.Sprint(total))
+	resp, err = p.do(ctx, "MOVE", uploadDir.JoinPath(".file"), nil, header)
+	if err != nil {
+		return fmt.Errorf("failed to assemble chunks: %w", err)
+	}
+	return expectStatus(resp, http.StatusCreated, http.StatusNoContent)
+}
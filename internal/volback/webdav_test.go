@@ -0,0 +1,206 @@
+package volback
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/webdav"
+)
+
+// startWebdavServer serves dir over WebDAV, requiring either basic auth with
+// volback:secret or the bearer token "token". Moving an upload collection's
+// .file concatenates its chunks the way Nextcloud does. The number of chunks
+// PUT is counted in chunkPuts, and the first failChunks of them fail.
+func startWebdavServer(t *testing.T, dir string, chunkPuts *atomic.Int32, failChunks int32) string {
+	t.Helper()
+
+	dav := &webdav.Handler{
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		basicOk := ok && user == "volback" && password == "secret"
+		if !basicOk && r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/uploads/") && r.Method == http.MethodPut {
+			if chunkPuts.Add(1) <= failChunks {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+		}
+
+		if r.Method == "MOVE" && strings.HasSuffix(r.URL.Path, "/.file") {
+			uploadDir := filepath.Join(dir, filepath.FromSlash(strings.TrimSuffix(r.URL.Path, "/.file")))
+			dst, err := url.Parse(r.Header.Get("Destination"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := assembleChunks(uploadDir, filepath.Join(dir, filepath.FromSlash(dst.Path))); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		dav.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL
+}
+
+func assembleChunks(uploadDir string, dst string) error {
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	slices.Sort(names)
+
+	var assembled bytes.Buffer
+	for _, name := range names {
+		chunk, err := os.ReadFile(filepath.Join(uploadDir, name))
+		if err != nil {
+			return err
+		}
+		assembled.Write(chunk)
+	}
+	if err := os.WriteFile(dst, assembled.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.RemoveAll(uploadDir)
+}
+
+func TestWebdavPushPuller(t *testing.T) {
+	testCases := []struct {
+		name       string
+		loc        WebdavOptions
+		retries    int
+		failChunks int32
+		wantChunks int32
+	}{
+		{
+			name: "basic auth",
+			loc:  WebdavOptions{Webdav_User: "volback", Webdav_Password: "secret"},
+		},
+		{
+			name: "bearer token",
+			loc:  WebdavOptions{Webdav_BearerToken: "token"},
+		},
+		{
+			name:       "chunked",
+			loc:        WebdavOptions{Webdav_User: "volback", Webdav_Password: "secret", Webdav_ChunkSize: 8},
+			wantChunks: 5,
+		},
+		{
+			name:       "chunked with failing chunks",
+			loc:        WebdavOptions{Webdav_User: "volback", Webdav_Password: "secret", Webdav_ChunkSize: 8},
+			retries:    2,
+			failChunks: 2,
+			wantChunks: 7,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, collection := range []string{"files", "uploads"} {
+				if err := os.Mkdir(filepath.Join(dir, collection), 0755); err != nil {
+					t.Fatalf("unexpected error creating %s collection: %v", collection, err)
+				}
+			}
+			var chunkPuts atomic.Int32
+			srvURL := startWebdavServer(t, dir, &chunkPuts, tc.failChunks)
+
+			loc := tc.loc
+			loc.Webdav_Url = srvURL + "/files"
+			if loc.Webdav_ChunkSize > 0 {
+				loc.Webdav_ChunkUploadsUrl = srvURL + "/uploads"
+			}
+			p, err := newWebdavPushPuller(&loc, RetryPolicy{Retries: tc.retries, Backoff: time.Millisecond, MaxBackoff: defaultRetryMaxBackoff})
+			if err != nil {
+				t.Fatalf("unexpected error creating webdav push puller: %v", err)
+			}
+
+			given := "backup contents sent over webdav!"
+			if err := p.Push(t.Context(), strings.NewReader(given), "backups/nested/backup.ct"); err != nil {
+				t.Fatalf("unexpected error pushing: %v", err)
+			}
+
+			onDisk, err := os.ReadFile(filepath.Join(dir, "files", "backups", "nested", "backup.ct"))
+			if err != nil {
+				t.Fatalf("unexpected error reading pushed file: %v", err)
+			}
+			if diff := cmp.Diff(given, string(onDisk)); diff != "" {
+				t.Errorf("pushed contents mismatch (-want +got):\n%s", diff)
+			}
+			if got := chunkPuts.Load(); got != tc.wantChunks {
+				t.Errorf("got %d chunks, want %d", got, tc.wantChunks)
+			}
+
+			r, err := p.Pull(t.Context(), "backups/nested/backup.ct")
+			if err != nil {
+				t.Fatalf("unexpected error pulling: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error reading pulled file: %v", err)
+			}
+			r.(io.Closer).Close()
+
+			if diff := cmp.Diff(given, string(got)); diff != "" {
+				t.Errorf("pulled contents mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWebdavPushPuller_Unauthorized(t *testing.T) {
+	var chunkPuts atomic.Int32
+	srvURL := startWebdavServer(t, t.TempDir(), &chunkPuts, 0)
+
+	p, err := newWebdavPushPuller(&WebdavOptions{Webdav_Url: srvURL, Webdav_BearerToken: "wrong"}, RetryPolicy{})
+	if err != nil {
+		t.Fatalf("unexpected error creating webdav push puller: %v", err)
+	}
+
+	if err := p.Push(t.Context(), strings.NewReader("data"), "backup.ct"); err == nil {
+		t.Fatalf("expected unauthorized push to fail, got nil")
+	}
+}
+
+func TestNewWebdavPushPuller_Timeouts(t *testing.T) {
+	p, err := newWebdavPushPuller(&WebdavOptions{Webdav_Url: "https://dav.example.com"}, RetryPolicy{})
+	if err != nil {
+		t.Fatalf("unexpected error creating webdav push puller: %v", err)
+	}
+
+	transport, ok := p.client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected an *http.Transport, got %T", p.client.Transport)
+	}
+	if transport.TLSHandshakeTimeout != httpTLSHandshakeTimeout || transport.ResponseHeaderTimeout != httpResponseHeaderTimeout {
+		t.Errorf("expected handshake and response timeouts, got %s and %s", transport.TLSHandshakeTimeout, transport.ResponseHeaderTimeout)
+	}
+}
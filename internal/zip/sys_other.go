@@ -0,0 +1,18 @@
+//go:build !unix
+
+package zip
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// deviceID is unsupported on this platform, so every file is assumed to be on
+// the same filesystem
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+func mkfifo(path string, perm uint32) error {
+	return fmt.Errorf("cannot create fifo %s: unsupported platform", path)
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package zip
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// deviceID returns the ID of the device containing the file described by info
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+func mkfifo(path string, perm uint32) error {
+	return syscall.Mkfifo(path, perm)
+}
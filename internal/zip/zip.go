@@ -3,14 +3,159 @@ package zip
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-// CreateArchiveFromPath returns a *zip.Reader from a filesystem path
-func CreateArchiveFromPath(path string) (io.Reader, error) {
+// SpecialFilePolicy determines what happens to FIFOs, sockets and device
+// nodes encountered while archiving
+type SpecialFilePolicy string
+
+const (
+	// SpecialFileSkip leaves the file out of the archive entirely
+	SpecialFileSkip SpecialFilePolicy = "skip"
+	// SpecialFileMetadata records the file's header, but never reads from it
+	SpecialFileMetadata SpecialFilePolicy = "metadata"
+	// SpecialFileError aborts the archive
+	SpecialFileError SpecialFilePolicy = "error"
+)
+
+// ReadErrorPolicy determines what happens when a file can't be read while
+// archiving
+type ReadErrorPolicy string
+
+const (
+	// ReadErrorFail aborts the archive
+	ReadErrorFail ReadErrorPolicy = "fail"
+	// ReadErrorSkip leaves the file out and records a warning on the Archive
+	ReadErrorSkip ReadErrorPolicy = "skip"
+)
+
+// truncatedDir holds an empty marker entry, named after it, for every file
+// whose entry was cut short by a read error. Marked entries are left out when
+// the archive is unpacked.
+const truncatedDir = ".volback-truncated/"
+
+// ArchiveOptions configures how a path is walked when creating an archive.
+// The zero value skips every special file, crosses filesystem boundaries and
+// fails on unreadable files.
+type ArchiveOptions struct {
+	// OneFileSystem prevents descending into directories that live on a
+	// different filesystem than the root, i.e. mount points
+	OneFileSystem bool
+
+	Fifos   SpecialFilePolicy
+	Sockets SpecialFilePolicy
+	Devices SpecialFilePolicy
+
+	ReadErrors ReadErrorPolicy
+}
+
+func (o ArchiveOptions) policyFor(mode fs.FileMode) (SpecialFilePolicy, string) {
+	var p SpecialFilePolicy
+	var kind string
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		p, kind = o.Fifos, "fifo"
+	case mode&fs.ModeSocket != 0:
+		p, kind = o.Sockets, "socket"
+	case mode&fs.ModeDevice != 0:
+		p, kind = o.Devices, "device"
+	default:
+		return SpecialFileError, "irregular file"
+	}
+	if p == "" {
+		p = SpecialFileSkip
+	}
+	return p, kind
+}
+
+// fileError is an error reading from the file being archived, as opposed to
+// an error writing the archive itself
+type fileError struct {
+	path string
+	err  error
+}
+
+func (e *fileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.path, e.err)
+}
+
+func (e *fileError) Unwrap() error {
+	return e.err
+}
+
+// fileReader tags every error returned by r as a *fileError, and counts the
+// bytes read from it
+type fileReader struct {
+	path  string
+	r     io.Reader
+	count *atomic.Int64
+}
+
+func (fr *fileReader) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	fr.count.Add(int64(n))
+	if err != nil && err != io.EOF {
+		err = &fileError{fr.path, err}
+	}
+	return n, err
+}
+
+// Archive is a zip archive being streamed from the filesystem
+type Archive struct {
+	*io.PipeReader
+
+	mu       sync.Mutex
+	warnings []error
+
+	files atomic.Int64
+	bytes atomic.Int64
+}
+
+// Progress returns how many regular files have been archived so far, and how
+// many bytes have been read from regular files
+func (a *Archive) Progress() (files int64, bytes int64) {
+	return a.files.Load(), a.bytes.Load()
+}
+
+// Warnings returns the files that were skipped because they couldn't be read.
+// The list is only complete once the Archive has been read to EOF.
+func (a *Archive) Warnings() []error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.warnings)
+}
+
+func (a *Archive) warn(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.warnings = append(a.warnings, err)
+}
+
+// skippable reports whether err is a read error that opts allows us to record
+// as a warning instead of failing the archive
+func (a *Archive) skippable(err error, opts ArchiveOptions) bool {
+	var fe *fileError
+	if opts.ReadErrors != ReadErrorSkip || !errors.As(err, &fe) {
+		return false
+	}
+	log.Printf("Skipping unreadable file: %v\n", err)
+	a.warn(err)
+	return true
+}
+
+// CreateArchiveFromPath returns an *Archive streaming a zip of a filesystem path
+func CreateArchiveFromPath(path string, opts ArchiveOptions) (*Archive, error) {
 
 	pinfo, err := os.Stat(path)
 	if err != nil {
@@ -18,61 +163,152 @@ func CreateArchiveFromPath(path string) (io.Reader, error) {
 	}
 
 	pr, pw := io.Pipe()
+	a := &Archive{PipeReader: pr}
 
-	if pinfo.IsDir() {
-		go func(pw *io.PipeWriter) {
-			zw := zip.NewWriter(pw)
+	go func(pw *io.PipeWriter) {
+		zw := zip.NewWriter(pw)
 
-			// th, err := zip.FileInfoHeader(pinfo, "")
-			// if err != nil {
-			// 	pw.CloseWithError(fmt.Errorf("zip header creation: %v", err))
-			// 	return
-			// }
-			// th.Name = filepath.Base(path)
+		var err error
+		if pinfo.IsDir() {
+			err = a.addDir(zw, path, pinfo, opts)
+		} else {
+			err = a.addEntry(zw, path, filepath.Base(path), pinfo, opts)
+			if err != nil && a.skippable(err, opts) {
+				err = nil
+			}
+		}
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
 
-			// if err := zw.WriteHeader(th); err != nil {
-			// 	pw.CloseWithError(fmt.Errorf("writing header to zip: %v", err))
-			// 	return
-			// }
+		if err := zw.Close(); err != nil {
+			pw.CloseWithError(fmt.Errorf("finishing zip: %v", err))
+			return
+		}
+		pw.Close()
+	}(pw)
+
+	return a, nil
+}
 
-			zw.AddFS(os.DirFS(path))
+// addDir walks root and adds every entry below it to zw, named relative to root
+func (a *Archive) addDir(zw *zip.Writer, root string, rootInfo fs.FileInfo, opts ArchiveOptions) error {
+	rootDev, hasDev := deviceID(rootInfo)
 
-			zw.Close()
-			pw.Close()
-		}(pw)
-	} else {
-		fd, err := os.Open(path)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return nil, fmt.Errorf("Open(%s): %v", path, err)
+			// The entry itself, or the listing of a directory, is unreadable
+			if a.skippable(&fileError{path, err}, opts) {
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			return err
 		}
-
-		go func(r io.Reader, pw *io.PipeWriter) {
-			zw := zip.NewWriter(pw)
-
-			zh, err := zip.FileInfoHeader(pinfo)
-			if err != nil {
-				pw.CloseWithError(fmt.Errorf("zip header creation: %v", err))
-				return
+		if path == root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if a.skippable(&fileError{path, err}, opts) {
+				return nil
 			}
-			zh.Name = filepath.Base(path)
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
 
-			fw, err := zw.CreateHeader(zh)
-			if err != nil {
-				pw.CloseWithError(fmt.Errorf("writing header to zip: %v", err))
-				return
+		if err := a.addEntry(zw, path, name, info, opts); err != nil {
+			if a.skippable(err, opts) {
+				return nil
 			}
+			return err
+		}
 
-			if _, err := io.Copy(fw, r); err != nil {
-				pw.CloseWithError(fmt.Errorf("writing contents to zip: %v", err))
-				return
+		if d.IsDir() && opts.OneFileSystem && hasDev {
+			if dev, ok := deviceID(info); ok && dev != rootDev {
+				// Keep the mount point itself, but none of its contents
+				return fs.SkipDir
 			}
+		}
+		return nil
+	})
+}
 
-			zw.Close()
-			pw.Close()
-		}(fd, pw)
+// addEntry writes a single file, directory, symlink or special file to zw.
+// Special files are handled according to opts and are never opened. Failures
+// to read the file are returned as a *fileError.
+func (a *Archive) addEntry(zw *zip.Writer, path string, name string, info fs.FileInfo, opts ArchiveOptions) error {
+	mode := info.Mode()
+
+	zh, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return fmt.Errorf("zip header creation for %s: %v", path, err)
 	}
+	zh.Name = name
+
+	switch {
+	case mode.IsDir():
+		zh.Name += "/"
+		_, err := zw.CreateHeader(zh)
+		return err
+	case mode.IsRegular():
+		zh.Method = zip.Deflate
+		fd, err := os.Open(path)
+		if err != nil {
+			return &fileError{path, err}
+		}
+		defer fd.Close()
 
-	return pr, nil
+		fw, err := zw.CreateHeader(zh)
+		if err != nil {
+			return fmt.Errorf("writing header to zip: %v", err)
+		}
+		if _, err := io.Copy(fw, &fileReader{path, fd, &a.bytes}); err != nil {
+			var fe *fileError
+			if errors.As(err, &fe) {
+				// The header has already been streamed out, so the truncated
+				// entry can't be taken back. Mark it instead, so it isn't
+				// restored as if it were whole.
+				if _, merr := zw.CreateHeader(&zip.FileHeader{Name: truncatedDir + name, Method: zip.Store}); merr != nil {
+					return fmt.Errorf("marking %s as truncated: %v", path, merr)
+				}
+				return &fileError{path, fmt.Errorf("truncated after read error: %w", fe.err)}
+			}
+			return fmt.Errorf("writing contents of %s to zip: %v", path, err)
+		}
+		a.files.Add(1)
+		return nil
+	case mode&fs.ModeSymlink != 0:
+		// zip stores the link target as the contents of the entry
+		target, err := os.Readlink(path)
+		if err != nil {
+			return &fileError{path, err}
+		}
+		fw, err := zw.CreateHeader(zh)
+		if err != nil {
+			return fmt.Errorf("writing header to zip: %v", err)
+		}
+		_, err = io.WriteString(fw, target)
+		return err
+	}
+
+	policy, kind := opts.policyFor(mode)
+	switch policy {
+	case SpecialFileSkip:
+		return nil
+	case SpecialFileMetadata:
+		zh.Method = zip.Store
+		_, err := zw.CreateHeader(zh)
+		return err
+	default:
+		return fmt.Errorf("%s is a %s (%s)", path, kind, mode)
+	}
 }
 
 func UnpackArchiveToPath(r io.Reader, extractPath string) error {
@@ -91,8 +327,34 @@ func UnpackArchiveToPath(r io.Reader, extractPath string) error {
 		return err
 	}
 
+	truncated := map[string]bool{}
+	for _, zf := range zr.File {
+		if name, ok := strings.CutPrefix(zf.Name, truncatedDir); ok {
+			truncated[name] = true
+		}
+	}
+
+	// Symlinks are created once everything else has been written, so no
+	// entry can be written through one
+	var symlinks []*zip.File
 	for _, zf := range zr.File {
-		path := filepath.Join(extractPath, zf.Name)
+		if strings.HasPrefix(zf.Name, truncatedDir) {
+			continue
+		}
+		if truncated[zf.Name] {
+			log.Printf("Skipping restore of %s, it was cut short by a read error when it was backed up\n", zf.Name)
+			continue
+		}
+
+		path, err := entryPath(extractPath, zf.Name)
+		if err != nil {
+			return err
+		}
+
+		if zf.Mode()&fs.ModeSymlink != 0 {
+			symlinks = append(symlinks, zf)
+			continue
+		}
 
 		if zf.FileInfo().IsDir() {
 			if err := os.MkdirAll(path, zf.Mode()); err != nil {
@@ -101,6 +363,13 @@ func UnpackArchiveToPath(r io.Reader, extractPath string) error {
 			continue
 		}
 
+		if mode := zf.Mode(); !mode.IsRegular() {
+			if err := unpackSpecial(zf, path); err != nil {
+				return err
+			}
+			continue
+		}
+
 		// Open the file in the archive
 		rc, err := zf.Open()
 		if err != nil {
@@ -123,5 +392,61 @@ func UnpackArchiveToPath(r io.Reader, extractPath string) error {
 
 	}
 
+	for _, zf := range symlinks {
+		path, _ := entryPath(extractPath, zf.Name)
+		if err := unpackSymlink(zf, extractPath, path); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// entryPath returns where the archive entry called name is unpacked below
+// extractPath. Names that would land anywhere else are rejected.
+func entryPath(extractPath, name string) (string, error) {
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("refusing to unpack %q outside of %s", name, extractPath)
+	}
+	return filepath.Join(extractPath, local), nil
+}
+
+// unpackSymlink recreates the symlink recorded by zf at path. Links to
+// anywhere outside of extractPath are rejected, since a crafted or corrupt
+// archive could otherwise point them at arbitrary places.
+func unpackSymlink(zf *zip.File, extractPath, path string) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	target, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(string(target)) {
+		return fmt.Errorf("refusing to restore symlink %s to absolute path %s", zf.Name, target)
+	}
+	rel, err := filepath.Rel(extractPath, filepath.Join(filepath.Dir(path), string(target)))
+	if err != nil || !filepath.IsLocal(rel) && rel != "." {
+		return fmt.Errorf("refusing to restore symlink %s to %s outside of %s", zf.Name, target, extractPath)
+	}
+	return os.Symlink(string(target), path)
+}
+
+// unpackSpecial recreates FIFOs recorded in the archive. Sockets and device
+// nodes can't be meaningfully recreated, so they are skipped. Symlinks are
+// handled by unpackSymlink.
+func unpackSpecial(zf *zip.File, path string) error {
+	mode := zf.Mode()
+
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return mkfifo(path, uint32(mode.Perm()))
+	default:
+		log.Printf("Skipping restore of %s (%s)\n", zf.Name, mode)
+		return nil
+	}
+}
@@ -1,6 +1,8 @@
 package zip
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"io"
 	"os"
@@ -16,7 +18,7 @@ import (
 
 func TestCreateArchiveFromPath_Directory(t *testing.T) {
 
-	r, err := CreateArchiveFromPath("./testdata/testdirectory")
+	r, err := CreateArchiveFromPath("./testdata/testdirectory", ArchiveOptions{})
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -65,7 +67,7 @@ func TestCreateArchiveFromPath_Directory(t *testing.T) {
 
 func TestCreateArchiveFromPath_Path(t *testing.T) {
 
-	r, err := CreateArchiveFromPath("./testdata/testfile.txt")
+	r, err := CreateArchiveFromPath("./testdata/testfile.txt", ArchiveOptions{})
 
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -246,3 +248,130 @@ func TestUnpackArchiveToPath_Directory(t *testing.T) {
 		t.Errorf("expected file 'a.txt' in directory 'a', got: %v", aEntries)
 	}
 }
+
+func TestArchiveReadErrorPolicy(t *testing.T) {
+	// A file that disappears between the walk and the read is the portable way
+	// to provoke a read error, since permissions don't stop root
+	path := filepath.Join(t.TempDir(), "vanished.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("unexpected error stating file: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("unexpected error removing file: %v", err)
+	}
+
+	testCases := []struct {
+		policy       ReadErrorPolicy
+		wantSkipped  bool
+		wantWarnings int
+	}{
+		{policy: "", wantSkipped: false, wantWarnings: 0},
+		{policy: ReadErrorFail, wantSkipped: false, wantWarnings: 0},
+		{policy: ReadErrorSkip, wantSkipped: true, wantWarnings: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			opts := ArchiveOptions{ReadErrors: tc.policy}
+			a := &Archive{}
+			zw := zip.NewWriter(io.Discard)
+
+			err := a.addEntry(zw, path, "vanished.txt", info, opts)
+			if err == nil {
+				t.Fatalf("expected error reading vanished file, got nil")
+			}
+
+			if got := a.skippable(err, opts); got != tc.wantSkipped {
+				t.Errorf("skippable() = %v, want %v", got, tc.wantSkipped)
+			}
+			if got := len(a.Warnings()); got != tc.wantWarnings {
+				t.Errorf("got %d warnings, want %d", got, tc.wantWarnings)
+			}
+		})
+	}
+}
+
+// readFailingFile returns a regular file that opens but fails to read, or
+// skips the test where there isn't one
+func readFailingFile(t *testing.T) string {
+	t.Helper()
+	// Reading /proc/self/mem from offset 0 fails with EIO on Linux
+	const path = "/proc/self/mem"
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		t.Skipf("%s isn't available to provoke a read error", path)
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Skipf("%s can't be opened: %v", path, err)
+	}
+	defer fd.Close()
+	if _, err := fd.Read(make([]byte, 1)); err == nil {
+		t.Skipf("%s can be read", path)
+	}
+	return path
+}
+
+func TestCreateArchiveFromPath_TruncatedEntry(t *testing.T) {
+	path := readFailingFile(t)
+
+	a, err := CreateArchiveFromPath(path, ArchiveOptions{ReadErrors: ReadErrorSkip})
+	if err != nil {
+		t.Fatalf("unexpected error creating archive: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, a); err != nil {
+		t.Fatalf("unexpected error reading archive: %v", err)
+	}
+	if got := len(a.Warnings()); got != 1 {
+		t.Fatalf("expected a warning about the truncated file, got %d: %v", got, a.Warnings())
+	}
+
+	extractPath := filepath.Join(t.TempDir(), "restore")
+	if err := UnpackArchiveToPath(&buf, extractPath); err != nil {
+		t.Fatalf("unexpected error unpacking: %v", err)
+	}
+	entries, err := os.ReadDir(extractPath)
+	if err != nil {
+		t.Fatalf("unexpected error reading restore: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected the truncated file and its marker to be left out, got: %v", entries)
+	}
+}
+
+func TestArchiveProgress(t *testing.T) {
+	dir := t.TempDir()
+	given := map[string]string{"a.txt": "first file", "sub/b.txt": "second, longer file"}
+	var wantBytes int64
+	for name, contents := range given {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("unexpected error creating directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatalf("unexpected error writing file: %v", err)
+		}
+		wantBytes += int64(len(contents))
+	}
+
+	a, err := CreateArchiveFromPath(dir, ArchiveOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, a); err != nil {
+		t.Fatalf("unexpected error reading archive: %v", err)
+	}
+
+	files, bytes := a.Progress()
+	if files != int64(len(given)) {
+		t.Errorf("expected %d files archived, got %d", len(given), files)
+	}
+	if bytes != wantBytes {
+		t.Errorf("expected %d bytes archived, got %d", wantBytes, bytes)
+	}
+}
@@ -0,0 +1,172 @@
+//go:build unix
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCreateArchiveFromPath_SpecialFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "regular.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(dir, "fifo"), 0644); err != nil {
+		t.Fatalf("unexpected error creating fifo: %v", err)
+	}
+
+	testCases := []struct {
+		name      string
+		policy    SpecialFilePolicy
+		wantFiles []string
+		wantErr   bool
+	}{
+		{name: "default", policy: "", wantFiles: []string{"regular.txt"}},
+		{name: "skip", policy: SpecialFileSkip, wantFiles: []string{"regular.txt"}},
+		{name: "metadata", policy: SpecialFileMetadata, wantFiles: []string{"fifo", "regular.txt"}},
+		{name: "error", policy: SpecialFileError, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := CreateArchiveFromPath(dir, ArchiveOptions{Fifos: tc.policy})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// A hang here means the fifo was opened for reading
+			buf, err := io.ReadAll(r)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error archiving fifo, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error reading archive: %v", err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+			if err != nil {
+				t.Fatalf("unexpected error opening archive: %v", err)
+			}
+			var got []string
+			for _, zf := range zr.File {
+				got = append(got, zf.Name)
+			}
+			slices.Sort(got)
+
+			if diff := cmp.Diff(tc.wantFiles, got); diff != "" {
+				t.Errorf("archive entries mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// testEntry is an entry written to an archive built by hand
+type testEntry struct {
+	name string
+	mode os.FileMode
+	// body is a regular file's contents, or a symlink's target
+	body string
+}
+
+func buildArchive(t *testing.T, entries []testEntry) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		zh := &zip.FileHeader{Name: e.name, Method: zip.Store}
+		zh.SetMode(e.mode)
+		w, err := zw.CreateHeader(zh)
+		if err != nil {
+			t.Fatalf("unexpected error adding %s: %v", e.name, err)
+		}
+		if _, err := io.WriteString(w, e.body); err != nil {
+			t.Fatalf("unexpected error writing %s: %v", e.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error closing archive: %v", err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestUnpackArchiveToPath_Symlinks(t *testing.T) {
+	dir := t.TempDir()
+	extractPath := filepath.Join(dir, "restore")
+	r := buildArchive(t, []testEntry{
+		{name: "data/", mode: os.ModeDir | 0o755},
+		{name: "data/a.txt", mode: 0o644, body: "a"},
+		{name: "link", mode: os.ModeSymlink | 0o777, body: "data/a.txt"},
+		{name: "data/up", mode: os.ModeSymlink | 0o777, body: "../link"},
+	})
+
+	if err := UnpackArchiveToPath(r, extractPath); err != nil {
+		t.Fatalf("unexpected error unpacking: %v", err)
+	}
+	for _, link := range []string{"link", "data/up"} {
+		got, err := os.ReadFile(filepath.Join(extractPath, link))
+		if err != nil {
+			t.Fatalf("unexpected error reading through %s: %v", link, err)
+		}
+		if string(got) != "a" {
+			t.Errorf("expected %s to lead to a.txt, got %q", link, got)
+		}
+	}
+}
+
+func TestUnpackArchiveToPath_Unsafe(t *testing.T) {
+	testCases := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{name: "parent name", entries: []testEntry{{name: "../escaped.txt", mode: 0o644, body: "x"}}},
+		{name: "nested parent name", entries: []testEntry{{name: "a/../../escaped.txt", mode: 0o644, body: "x"}}},
+		{name: "absolute name", entries: []testEntry{{name: "/escaped.txt", mode: 0o644, body: "x"}}},
+		{name: "absolute symlink", entries: []testEntry{{name: "x", mode: os.ModeSymlink | 0o777, body: "/etc"}}},
+		{name: "escaping symlink", entries: []testEntry{{name: "a/x", mode: os.ModeSymlink | 0o777, body: "../../outside"}}},
+		{
+			// Without deferring symlinks, a/escaped.txt would be written to
+			// the directory next to the restore
+			name: "write through symlink",
+			entries: []testEntry{
+				{name: "a", mode: os.ModeSymlink | 0o777, body: "../outside"},
+				{name: "a/escaped.txt", mode: 0o644, body: "x"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outside := filepath.Join(dir, "outside")
+			if err := os.Mkdir(outside, 0o755); err != nil {
+				t.Fatalf("unexpected error creating directory: %v", err)
+			}
+			extractPath := filepath.Join(dir, "restore")
+
+			if err := UnpackArchiveToPath(buildArchive(t, tc.entries), extractPath); err == nil {
+				t.Errorf("expected the archive to be rejected")
+			}
+
+			for _, path := range []string{filepath.Join(dir, "escaped.txt"), filepath.Join(outside, "escaped.txt"), "/escaped.txt"} {
+				if _, err := os.Lstat(path); err == nil {
+					t.Errorf("expected nothing to be written to %s", path)
+				}
+			}
+			if _, err := os.Lstat(filepath.Join(extractPath, "x")); err == nil {
+				t.Errorf("expected the unsafe symlink not to be created")
+			}
+		})
+	}
+}